@@ -2,10 +2,8 @@ package xbt
 
 import (
 	"encoding/hex"
-	"errors"
-	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"strconv"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
 	"strings"
 )
 
@@ -38,58 +36,21 @@ func (dec *AddressDecoderV2) AddressDecode(addr string, opts ...interface{}) ([]
 
 //AddressEncode 地址编码
 func (dec *AddressDecoderV2) AddressEncode(publicKey []byte, opts ...interface{}) (string, error) {
-	if len(publicKey) != 32 {
-		//公钥hash处理
-		publicKey = owcrypt.PointDecompress(publicKey, owcrypt.ECC_CURVE_SECP256K1)
+	address, err := xbtTransaction.GetAddressByPublicKey(publicKey)
+	if err != nil {
+		return "", err
 	}
-	//fmt.Println("publicKey : ", hex.EncodeToString(publicKey) )
-
-	hash := owcrypt.Hash(publicKey, 0, owcrypt.HASH_ALG_SHA3_256)
-	//fmt.Println("publicHash : ", hex.EncodeToString(hash) )
-
-	publicHashCode := hash[:20]
-	publicHashCodeStr := hex.EncodeToString(publicHashCode)
-	//fmt.Println("publicHashCode : ", publicHashCodeStr )
 
-	address := "xB" + publicHashCodeStr
-	returnAddress, err := dec.CheckAddress(address)
-	if err!= nil {
-		return "", err
+	//可选：与xbt tools服务的结果进行比对
+	if dec.wm != nil {
+		dec.wm.crossCheckAddress(publicKey, address)
 	}
 
-	return returnAddress, nil
+	return address, nil
 }
 
 func (dec *AddressDecoderV2) CheckAddress(address string) (string, error){
-	if len(address) != 42 {
-		return "", errors.New("wrong address")
-	}
-
-	content := strings.ReplaceAll( strings.ToLower( address ), "xb", "")
-	//fmt.Println("content : ", content )
-
-	publicHashCodeBytes := []byte( content )
-	hash := owcrypt.Hash(publicHashCodeBytes, 0, owcrypt.HASH_ALG_SHA3_256)
-	//fmt.Println("hash : ", hex.EncodeToString(hash), ",hash len :", len(hex.EncodeToString(hash)), ",content len :", len(content) )
-
-	hashCode := hex.EncodeToString(hash)[len( hex.EncodeToString(hash) ) - len(content) : ]
-	//fmt.Println("hashCode : ", hashCode )
-
-	returnAddress := "xB"
-
-	for i := 0; i < len(hashCode); i++ {
-		n, _ := strconv.ParseUint(hashCode[i:i+1], 16, 32)
-		codeInt := int64(n)
-
-		if codeInt >= 8 {
-			returnAddress = returnAddress + strings.ToUpper( content[i:i+1] )
-		}else{
-			returnAddress = returnAddress + content[i:i+1]
-		}
-	}
-	//fmt.Println("returnAddress : ", returnAddress )
-
-	return returnAddress, nil
+	return xbtTransaction.ChecksumAddress(address)
 }
 
 // AddressVerify 地址校验
@@ -0,0 +1,413 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const defaultBackfillWorkers = 4
+
+//backfillIDPattern 回填任务id用于进度文件名，只允许字母、数字、下划线及横线
+var backfillIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+//BackfillDeposit 回填扫描找到的充值
+type BackfillDeposit struct {
+	TxID        string `json:"txid"`
+	BlockHeight uint64 `json:"blockHeight"`
+	BlockHash   string `json:"blockHash"`
+	From        string `json:"from"`
+	Amount      string `json:"amount"`
+}
+
+//BackfillNotifyFailure 回填时投递失败的交易及观测者，由回填任务使用相同的扫描地址重新投递
+type BackfillNotifyFailure struct {
+	TxID        string           `json:"txid"`
+	BlockHeight uint64           `json:"blockHeight"`
+	Targets     []DeliveryTarget `json:"targets"`
+}
+
+//BackfillProgress 回填扫描的进度及结果，保存在数据目录，重启后可继续
+type BackfillProgress struct {
+	ID              string                        `json:"id"`
+	StartHeight     uint64                        `json:"startHeight"`
+	EndHeight       uint64                        `json:"endHeight"`
+	Addresses       []string                      `json:"addresses"`
+	CompletedChunks []uint64                      `json:"completedChunks"` //已完成的区块窗口的起始高度
+	Deposits        map[string][]*BackfillDeposit `json:"deposits"`        //按地址汇总的充值
+	NotifyFailures  []*BackfillNotifyFailure      `json:"notifyFailures"`  //投递失败待重新投递的交易
+	Finished        bool                          `json:"finished"`
+}
+
+//Backfill 扫描[start, end]区间内与addresses相关的交易并通知观测者，不影响实时扫描的高度
+//id相同的任务从保存的进度继续，返回按地址汇总的充值
+func (bs *XBTBlockScanner) Backfill(id string, start, end uint64, addresses []string) (*BackfillProgress, error) {
+	if !backfillIDPattern.MatchString(id) || start == 0 || start > end || len(addresses) == 0 {
+		return nil, fmt.Errorf("wrong backfill params, id: %s, start: %d, end: %d, addresses: %d", id, start, end, len(addresses))
+	}
+
+	progress, err := bs.loadBackfillProgress(id, start, end, addresses)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Finished {
+		return progress, nil
+	}
+
+	targets := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		targets[a] = true
+	}
+	completed := make(map[uint64]bool, len(progress.CompletedChunks))
+	for _, c := range progress.CompletedChunks {
+		completed[c] = true
+	}
+
+	chunkSize := bs.BlockRangeSize
+	if chunkSize == 0 {
+		chunkSize = 1
+	}
+	chunks := make(chan uint64)
+	go func() {
+		defer close(chunks)
+		for h := start; h <= end; h += chunkSize {
+			if !completed[h] {
+				chunks <- h
+			}
+			if end-h < chunkSize {
+				break
+			}
+		}
+	}()
+
+	workers := bs.BackfillWorkers
+	if workers <= 0 {
+		workers = defaultBackfillWorkers
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunkStart := range chunks {
+				chunkEnd := chunkStart + chunkSize - 1
+				if chunkEnd > end {
+					chunkEnd = end
+				}
+
+				deposits, failures, err := bs.backfillChunk(chunkStart, chunkEnd, targets)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					bs.wm.Log.Std.Error("backfill %s blocks %d-%d failed; unexpected error: %v", id, chunkStart, chunkEnd, err)
+				} else {
+					for _, d := range deposits {
+						progress.Deposits[d.address] = append(progress.Deposits[d.address], d.deposit)
+					}
+					progress.NotifyFailures = append(progress.NotifyFailures, failures...)
+					progress.CompletedChunks = append(progress.CompletedChunks, chunkStart)
+					if err := bs.saveBackfillProgress(progress); err != nil {
+						bs.wm.Log.Std.Error("backfill %s save progress failed; unexpected error: %v", id, err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return progress, firstErr
+	}
+
+	//所有窗口完成后重新投递失败的通知，仍有失败时任务不标记完成，再次调用时继续重试
+	if err := bs.retryBackfillNotifyFailures(progress, targets); err != nil {
+		return progress, err
+	}
+
+	for _, list := range progress.Deposits {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].BlockHeight < list[j].BlockHeight
+		})
+	}
+	progress.Finished = true
+	if err := bs.saveBackfillProgress(progress); err != nil {
+		return progress, err
+	}
+
+	bs.wm.Log.Std.Info("backfill %s blocks %d-%d finished", id, start, end)
+
+	return progress, nil
+}
+
+type backfillAddressDeposit struct {
+	address string
+	deposit *BackfillDeposit
+}
+
+//backfillChunk 扫描一个区块窗口，只提取与targets相关的交易，返回充值及投递失败的交易
+func (bs *XBTBlockScanner) backfillChunk(start, end uint64, targets map[string]bool) ([]backfillAddressDeposit, []*BackfillNotifyFailure, error) {
+	blocks, err := bs.wm.ApiClient.getBlocksByRange(start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//节点返回的区块不完整时不标记完成，避免遗漏充值
+	if err := checkBlockRange(blocks, start, end); err != nil {
+		return nil, nil, err
+	}
+
+	result := make([]backfillAddressDeposit, 0)
+	failures := make([]*BackfillNotifyFailure, 0)
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if !targets[tx.From] && !targets[tx.To] {
+				continue
+			}
+
+			failedTargets, err := bs.backfillNotify(block, tx, targets, nil)
+			if _, ok := err.(*backfillExtractError); ok {
+				return nil, nil, err
+			} else if err != nil {
+				//投递失败的观测者记录在回填进度中，实时扫描的重扫不知道回填的扫描地址
+				failures = append(failures, &BackfillNotifyFailure{TxID: tx.TxID, BlockHeight: block.Height, Targets: failedTargets})
+			}
+
+			if targets[tx.To] {
+				result = append(result, backfillAddressDeposit{
+					address: tx.To,
+					deposit: &BackfillDeposit{
+						TxID:        tx.TxID,
+						BlockHeight: block.Height,
+						BlockHash:   block.Hash,
+						From:        tx.From,
+						Amount:      convertToAmount(tx.Amount, bs.wm.Decimal()),
+					},
+				})
+			}
+		}
+	}
+
+	return result, failures, nil
+}
+
+//backfillExtractError 回填时交易提取失败
+type backfillExtractError struct {
+	TxID        string
+	BlockHeight uint64
+	Reason      string
+}
+
+func (e *backfillExtractError) Error() string {
+	return fmt.Sprintf("extract tx %s in block %d failed: %s", e.TxID, e.BlockHeight, e.Reason)
+}
+
+//backfillNotify 以回填的扫描地址提取交易并通知观测者，only不为nil时只投递给其中记录的观测者及sourceKey
+func (bs *XBTBlockScanner) backfillNotify(block *Block, tx Transaction, targets map[string]bool, only map[string]bool) ([]DeliveryTarget, error) {
+	//观测者已登记的地址使用原来的sourceKey，否则以地址作为sourceKey
+	scanTargetFunc := func(target openwallet.ScanTarget) (string, bool) {
+		if !targets[target.Address] {
+			return "", false
+		}
+		if bs.ScanTargetFunc != nil {
+			if sourceKey, ok := bs.ScanTargetFunc(target); ok {
+				return sourceKey, true
+			}
+		}
+		return target.Address, true
+	}
+
+	extracted := bs.ExtractTransaction(block.Height, block.Hash, tx, scanTargetFunc)
+	if !extracted.Success {
+		return nil, &backfillExtractError{TxID: tx.TxID, BlockHeight: block.Height, Reason: extracted.Reason}
+	}
+	bs.applyExtParam(extracted.extractData, bs.confirmedStatus())
+	return bs.newExtractDataNotify(block.Height, extracted.extractData, only)
+}
+
+//retryBackfillNotifyFailures 重新投递回填时失败的通知，只投递给失败的观测者及sourceKey，仍然失败的保留在进度中
+func (bs *XBTBlockScanner) retryBackfillNotifyFailures(progress *BackfillProgress, targets map[string]bool) error {
+	if len(progress.NotifyFailures) == 0 {
+		return nil
+	}
+
+	var (
+		remain   []*BackfillNotifyFailure
+		firstErr error
+		blocks   = make(map[uint64]*Block)
+	)
+	for _, f := range progress.NotifyFailures {
+		block, exist := blocks[f.BlockHeight]
+		if !exist {
+			b, err := bs.wm.ApiClient.getBlockByHeight(f.BlockHeight)
+			if err != nil {
+				remain = append(remain, f)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			block, blocks[f.BlockHeight] = b, b
+		}
+
+		var tx *Transaction
+		for i := range block.Transactions {
+			if block.Transactions[i].TxID == f.TxID {
+				tx = &block.Transactions[i]
+				break
+			}
+		}
+		if tx == nil {
+			//分叉后交易已不在该高度，不再投递
+			bs.wm.Log.Std.Info("backfill %s block height: %d tx: %s not found, drop failed notification", progress.ID, f.BlockHeight, f.TxID)
+			continue
+		}
+
+		only := make(map[string]bool, len(f.Targets))
+		for _, t := range f.Targets {
+			only[t.key()] = true
+		}
+		failedTargets, err := bs.backfillNotify(block, *tx, targets, only)
+		if err != nil {
+			if len(failedTargets) > 0 {
+				f.Targets = failedTargets
+			}
+			remain = append(remain, f)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	progress.NotifyFailures = remain
+	if err := bs.saveBackfillProgress(progress); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("backfill %s has %d failed notifications; unexpected error: %v", progress.ID, len(remain), firstErr)
+	}
+	return nil
+}
+
+//checkBlockRange 检查区块是否完整覆盖[start, end]且高度连续
+func checkBlockRange(blocks []*Block, start, end uint64) error {
+	if uint64(len(blocks)) != end-start+1 {
+		return fmt.Errorf("node returned %d blocks for range %d-%d", len(blocks), start, end)
+	}
+	for i, block := range blocks {
+		if block.Height != start+uint64(i) {
+			return fmt.Errorf("node returned block %d at position %d of range %d-%d", block.Height, i, start, end)
+		}
+	}
+	return nil
+}
+
+//GetBackfillProgress 查询回填任务的进度
+func (bs *XBTBlockScanner) GetBackfillProgress(id string) (*BackfillProgress, error) {
+	if !backfillIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("wrong backfill id: %s", id)
+	}
+
+	data, err := ioutil.ReadFile(bs.backfillProgressFile(id))
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &BackfillProgress{}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+func (bs *XBTBlockScanner) backfillProgressFile(id string) string {
+	return filepath.Join(bs.wm.Config.dbPath, "backfill_"+id+".json")
+}
+
+//loadBackfillProgress 读取已保存的进度，没有时创建新的进度
+func (bs *XBTBlockScanner) loadBackfillProgress(id string, start, end uint64, addresses []string) (*BackfillProgress, error) {
+	progress, err := bs.GetBackfillProgress(id)
+	if os.IsNotExist(err) {
+		return &BackfillProgress{
+			ID:          id,
+			StartHeight: start,
+			EndHeight:   end,
+			Addresses:   addresses,
+			Deposits:    make(map[string][]*BackfillDeposit),
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if progress.StartHeight != start || progress.EndHeight != end || !sameAddresses(progress.Addresses, addresses) {
+		return nil, fmt.Errorf("backfill %s already exists with different height range or addresses", id)
+	}
+	if progress.Deposits == nil {
+		progress.Deposits = make(map[string][]*BackfillDeposit)
+	}
+
+	bs.wm.Log.Std.Info("backfill %s resumed, %d chunks completed", id, len(progress.CompletedChunks))
+
+	return progress, nil
+}
+
+//saveBackfillProgress 先写临时文件再替换，避免进度文件损坏
+func (bs *XBTBlockScanner) saveBackfillProgress(progress *BackfillProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	file := bs.backfillProgressFile(progress.ID)
+	if err := ioutil.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[string]bool, len(a))
+	for _, addr := range a {
+		set[addr] = true
+	}
+	for _, addr := range b {
+		if !set[addr] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,237 @@
+package xbt
+
+import (
+	"testing"
+
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+//testBackfillChain 高度3、12有充值到testDepositAddress，高度8由testDepositAddress转出到testOtherAddress
+func testBackfillChain(srv *xbtmock.Server) {
+	srv.AddBlocks(2)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "5"})
+	srv.AddBlocks(4)
+	srv.AddBlock(xbtmock.Tx{From: testDepositAddress, To: testOtherAddress, Amount: "2.5"})
+	srv.AddBlocks(3)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1.25"})
+	srv.AddBlocks(8)
+}
+
+func TestXBTBlockScanner_Backfill_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	testBackfillChain(srv)
+
+	wm := testMockWalletManager(t, srv, "backfillWorkers = 3")
+	bs := wm.Blockscanner
+	bs.BlockRangeSize = 5
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	head := srv.GetBlock(18)
+	bs.SaveLocalNewBlock(head.Height, head.Hash)
+
+	progress, err := bs.Backfill("history", 1, 20, []string{testDepositAddress, testOtherAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Finished || len(progress.CompletedChunks) != 4 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+
+	deposits := progress.Deposits[testDepositAddress]
+	if len(deposits) != 2 || deposits[0].BlockHeight != 3 || deposits[0].Amount != "5" ||
+		deposits[1].BlockHeight != 12 || deposits[1].Amount != "1.25" {
+		t.Errorf("unexpected deposits of %s: %+v", testDepositAddress, deposits)
+	}
+	if list := progress.Deposits[testOtherAddress]; len(list) != 1 || list[0].BlockHeight != 8 || list[0].From != testDepositAddress {
+		t.Errorf("unexpected deposits of %s: %+v", testOtherAddress, list)
+	}
+
+	//已登记的地址使用原来的sourceKey，未登记的地址以地址作为sourceKey
+	if n := len(observer.extractData(testAccountID)); n != 3 {
+		t.Errorf("notified %d records of %s, want 3", n, testAccountID)
+	}
+	if n := len(observer.extractData(testOtherAddress)); n != 3 {
+		t.Errorf("notified %d records of %s, want 3", n, testOtherAddress)
+	}
+
+	//回填不改变实时扫描的高度
+	height, hash, err := bs.GetLocalBlockHead()
+	if err != nil || height != head.Height || hash != head.Hash {
+		t.Errorf("local block head changed to %d %s, err: %v", height, hash, err)
+	}
+
+	//已完成的任务不再请求节点
+	requests := srv.Requests(xbtmock.PathBlockRange)
+	if _, err := bs.Backfill("history", 1, 20, []string{testOtherAddress, testDepositAddress}); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Requests(xbtmock.PathBlockRange); n != requests {
+		t.Errorf("finished backfill requested %d ranges", n-requests)
+	}
+
+	//同一id的参数不一致时报错
+	if _, err := bs.Backfill("history", 1, 10, []string{testDepositAddress}); err == nil {
+		t.Error("backfill with different params should fail")
+	}
+}
+
+func TestXBTBlockScanner_BackfillResume_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	testBackfillChain(srv)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	bs.BlockRangeSize = 5
+
+	//模拟重启前已完成前两个窗口
+	addresses := []string{testDepositAddress}
+	saved := &BackfillProgress{
+		ID:              "resume",
+		StartHeight:     1,
+		EndHeight:       20,
+		Addresses:       addresses,
+		CompletedChunks: []uint64{1, 6},
+		Deposits: map[string][]*BackfillDeposit{
+			testDepositAddress: {{TxID: srv.GetBlock(3).Txs[0].Hash, BlockHeight: 3, Amount: "5"}},
+		},
+	}
+	if err := bs.saveBackfillProgress(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := bs.Backfill("resume", 1, 20, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := srv.Requests(xbtmock.PathBlockRange); n != 2 {
+		t.Errorf("resumed backfill requested %d ranges, want 2", n)
+	}
+	deposits := progress.Deposits[testDepositAddress]
+	if len(deposits) != 2 || deposits[0].BlockHeight != 3 || deposits[1].BlockHeight != 12 {
+		t.Errorf("unexpected deposits: %+v", deposits)
+	}
+
+	loaded, err := bs.GetBackfillProgress("resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Finished || len(loaded.CompletedChunks) != 4 {
+		t.Errorf("unexpected saved progress: %+v", loaded)
+	}
+}
+
+func TestXBTBlockScanner_BackfillPartialRange_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	testBackfillChain(srv)
+
+	wm := testMockWalletManager(t, srv, "backfillWorkers = 1")
+	bs := wm.Blockscanner
+	bs.BlockRangeSize = 5
+
+	//节点每次只返回3个区块，窗口不能标记为完成
+	srv.SetRangeLimit(3)
+	addresses := []string{testDepositAddress}
+	progress, err := bs.Backfill("partial", 1, 20, addresses)
+	if err == nil {
+		t.Fatal("backfill with truncated node response should fail")
+	}
+	if progress.Finished || len(progress.CompletedChunks) != 0 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+
+	//节点恢复后继续回填，不遗漏充值
+	srv.SetRangeLimit(0)
+	progress, err = bs.Backfill("partial", 1, 20, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposits := progress.Deposits[testDepositAddress]; len(deposits) != 2 {
+		t.Errorf("unexpected deposits: %+v", deposits)
+	}
+}
+
+func TestXBTBlockScanner_BackfillNotifyFailed_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	testBackfillChain(srv)
+
+	wm := testMockWalletManager(t, srv, "backfillWorkers = 1")
+	bs := wm.Blockscanner
+	bs.BlockRangeSize = 5
+	good := newTestMockObserver()
+	//3笔交易首次投递都失败，回填结束前重试时第一笔再失败一次
+	flaky := &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 4}
+	bs.AddObserver(good)
+	bs.AddObserver(flaky)
+
+	addresses := []string{testDepositAddress}
+	progress, err := bs.Backfill("notify", 1, 20, addresses)
+	if err == nil {
+		t.Fatal("backfill with failed notification should fail")
+	}
+	if progress.Finished || len(progress.CompletedChunks) != 4 || len(progress.NotifyFailures) != 1 ||
+		progress.NotifyFailures[0].BlockHeight != 3 || len(progress.NotifyFailures[0].Targets) != 1 ||
+		progress.NotifyFailures[0].Targets[0] != (DeliveryTarget{Observer: observerID(flaky), SourceKey: testAccountID}) {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+	if n := len(flaky.extractData(testAccountID)); n != 2 {
+		t.Errorf("flaky observer received %d records, want 2", n)
+	}
+
+	//回填的失败不记录为实时扫描的未扫记录
+	if records, _ := bs.GetUnscanRecords(); len(records) != 0 {
+		t.Errorf("unexpected unscan records: %+v", records)
+	}
+
+	//再次调用只获取失败交易所在的区块，重新投递给失败的观测者
+	requests := srv.Requests(xbtmock.PathBlockRange)
+	progress, err = bs.Backfill("notify", 1, 20, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Finished || len(progress.NotifyFailures) != 0 || srv.Requests(xbtmock.PathBlockRange) != requests+1 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	redelivered := flaky.extractData(testAccountID)
+	if len(redelivered) != 3 || redelivered[2].Transaction.TxID != srv.GetBlock(3).Txs[0].Hash {
+		t.Errorf("flaky observer received %d records", len(redelivered))
+	}
+	if n := len(good.extractData(testAccountID)); n != 3 {
+		t.Errorf("good observer received %d records, want 3", n)
+	}
+}
+
+func TestCheckBlockRange(t *testing.T) {
+	blocks := []*Block{{Height: 5}, {Height: 6}, {Height: 8}}
+	if err := checkBlockRange(blocks, 5, 7); err == nil {
+		t.Error("gapped range should fail")
+	}
+	if err := checkBlockRange(blocks[:2], 5, 7); err == nil {
+		t.Error("truncated range should fail")
+	}
+	if err := checkBlockRange(blocks[:2], 5, 6); err != nil {
+		t.Errorf("checkBlockRange failed: %v", err)
+	}
+}
+
+func TestXBTBlockScanner_BackfillID_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	testBackfillChain(srv)
+
+	bs := testMockWalletManager(t, srv).Blockscanner
+
+	for _, id := range []string{"", "../../x", "a/b", "a.b"} {
+		if _, err := bs.Backfill(id, 1, 5, []string{testDepositAddress}); err == nil {
+			t.Errorf("backfill with id %q should fail", id)
+		}
+		if _, err := bs.GetBackfillProgress(id); err == nil {
+			t.Errorf("GetBackfillProgress with id %q should fail", id)
+		}
+	}
+}
@@ -19,18 +19,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/asdine/storm"
-	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/shopspring/decimal"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
 	"time"
 )
 
 const (
 	//blockchainBucket  = "blockchain" //区块链数据集合
 	maxExtractingSize = 20 //并发的扫描线程数
+	defaultBlockRangeSize = 20 //追块时默认批量获取的区块数量
 )
 
 //XBTBlockScanner ontology的区块链扫描器
@@ -42,6 +42,24 @@ type XBTBlockScanner struct {
 	wm                   *WalletManager //钱包管理者
 	IsScanMemPool        bool           //是否扫描交易池
 	RescanLastBlockCount uint64         //重扫上N个区块数量
+	BlockRangeSize       uint64         //追块时每次批量获取的区块数量
+	Confirmations        uint64         //区块达到该确认数后才提取交易，0表示立即提取
+	NotifyUnconfirmed    bool           //确认数大于0时，新区块是否先发送未确认通知
+	confirmedHeight      uint64         //已按确认数提取的区块高度
+	confirmedInited      bool           //confirmedHeight是否已初始化
+	unconfirmedBlocks    map[uint64]*Block //已发送未确认通知的区块
+	memPoolTxs           map[string]string //已通知或已打包的交易池交易
+	memPoolMu            sync.Mutex
+	MaxReorgDepth        uint64         //最大分叉深度，超过时告警并停止回退，0表示不限制
+	ReorgAlertFunc       func(headHeight, maxDepth uint64) //分叉深度超过上限时的回调
+	BackfillWorkers      int            //回填扫描的并发数
+	MaxRescanAttempts    int            //未扫记录失败该次数后不再自动重扫，0表示不限制
+	RescanBackoff        time.Duration  //未扫记录第一次失败后的重扫间隔，之后每次失败翻倍
+	RescanMaxBackoff     time.Duration  //未扫记录的最大重扫间隔
+	unscanMu             sync.Mutex
+	MaxScanLag           uint64         //落后节点超过该区块数时状态标记为lagging，0表示不检查
+	StallTimeout         time.Duration  //节点有新区块但超过该时间没有扫描进度时状态标记为stalled，0表示不检查
+	metrics              *scannerMetrics
 	//socketIO             *gosocketio.Client //socketIO客户端
 	RPCServer int
 }
@@ -54,6 +72,7 @@ type ExtractResult struct {
 	BlockHeight uint64
 	BlockTime   int64
 	Success     bool
+	Reason      string //提取失败的原因
 }
 
 //SaveResult 保存结果
@@ -73,6 +92,17 @@ func NewXBTBlockScanner(wm *WalletManager) *XBTBlockScanner {
 	bs.wm = wm
 	bs.IsScanMemPool = false
 	bs.RescanLastBlockCount = 0
+	bs.BlockRangeSize = defaultBlockRangeSize
+	bs.unconfirmedBlocks = make(map[uint64]*Block)
+	bs.memPoolTxs = make(map[string]string)
+	bs.MaxReorgDepth = defaultMaxReorgDepth
+	bs.BackfillWorkers = defaultBackfillWorkers
+	bs.MaxScanLag = defaultMaxScanLag
+	bs.MaxRescanAttempts = defaultMaxRescanAttempts
+	bs.RescanBackoff = defaultRescanBackoff
+	bs.RescanMaxBackoff = defaultRescanMaxBackoff
+	bs.StallTimeout = defaultScanStallTimeout
+	bs.metrics = newScannerMetrics()
 
 	//设置扫描任务
 	bs.SetTask(bs.ScanBlockTask)
@@ -95,6 +125,9 @@ func (bs *XBTBlockScanner) SetRescanBlockHeight(height uint64) error {
 
 	bs.wm.Blockscanner.SaveLocalNewBlock(height, localBlock.Hash)
 
+	//重扫的区块需要重新发送确认通知
+	bs.resetConfirmedHeight(height)
+
 	return nil
 }
 
@@ -112,6 +145,11 @@ func (bs *XBTBlockScanner) ScanBlockTask() {
 	currentHash := blockHeader.Hash
 	var previousHeight uint64 = 0
 
+	bs.initConfirmedHeight(currentHeight)
+
+	//批量获取的区块缓存，追块模式下使用
+	pendingBlocks := make([]*Block, 0)
+
 	for {
 
 		if !bs.Scanning {
@@ -119,31 +157,35 @@ func (bs *XBTBlockScanner) ScanBlockTask() {
 			return
 		}
 
-		//获取最大高度
-		maxHeight, err := bs.wm.GetBlockHeight()
-		if err != nil {
-			//下一个高度找不到会报异常
-			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
-			break
-		}
+		if len(pendingBlocks) == 0 {
+			//获取最大高度
+			maxHeight, err := bs.wm.GetBlockHeight()
+			if err != nil {
+				//下一个高度找不到会报异常
+				bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
+				break
+			}
+			bs.metrics.setNodeHeight(maxHeight)
 
-		//是否已到最新高度
-		if currentHeight >= maxHeight {
-			bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
-			break
+			//是否已到最新高度
+			if currentHeight >= maxHeight {
+				bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
+				break
+			}
+
+			pendingBlocks, err = bs.fetchNextBlocks(currentHeight+1, maxHeight)
+			if err != nil {
+				bs.wm.Log.Std.Info("getBlockByHeight failed; unexpected error: %v", err)
+				break
+			}
 		}
 
 		//继续扫描下一个区块
 		currentHeight = currentHeight + 1
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		localBlock, err := bs.wm.ApiClient.getBlockByHeight(currentHeight)
-		if err != nil {
-			bs.wm.Log.Std.Info("getBlockByHeight failed; unexpected error: %v", err)
-			break
-		}
-
-		isFork := false
+		localBlock := pendingBlocks[0]
+		pendingBlocks = pendingBlocks[1:]
 
 		//判断hash是否上一区块的hash
 		if currentHash != localBlock.PrevBlockHash {
@@ -152,56 +194,57 @@ func (bs *XBTBlockScanner) ScanBlockTask() {
 			bs.wm.Log.Std.Info("block height: %d local hash = %s ", previousHeight, currentHash)
 			bs.wm.Log.Std.Info("block height: %d mainnet hash = %s ", previousHeight, localBlock.PrevBlockHash)
 
-			bs.wm.Log.Std.Info("delete recharge records on block height: %d.", previousHeight)
-
-			//删除上一区块链的所有充值记录
-			//bs.DeleteRechargesByHeight(currentHeight - 1)
-			forkBlock, _ := bs.GetLocalBlock(previousHeight)
-			//删除上一区块链的未扫记录
-			bs.wm.Blockscanner.DeleteUnscanRecord(previousHeight)
-			currentHeight = previousHeight - 1 //倒退2个区块重新扫描
-			if currentHeight <= 0 {
-				currentHeight = 1
+			//往回查找本地与节点hash一致的共同祖先区块
+			ancestor, orphans, err := bs.findCommonAncestor(previousHeight, currentHash)
+			if err != nil {
+				bs.wm.Log.Std.Error("block scanner can not find common ancestor; unexpected error: %v", err)
+				break
 			}
 
-			localBlock, err = bs.GetLocalBlock(currentHeight)
-			if err != nil && err != storm.ErrNotFound {
-				bs.wm.Log.Std.Error("block scanner can not get local block; unexpected error: %v", err)
-				break
-			} else if err == storm.ErrNotFound {
-				//查找core钱包的RPC
-				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
+			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s, orphaned blocks: %d .", ancestor.Height, ancestor.Hash, len(orphans))
+			bs.metrics.forked()
 
-				localBlock, err = bs.wm.ApiClient.getBlockByHeight(currentHeight)
-				if err != nil {
-					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
-					break
-				}
+			//重新记录一个新扫描起点
+			bs.wm.Blockscanner.SaveLocalNewBlock(ancestor.Height, ancestor.Hash)
+			bs.SaveLocalBlock(ancestor)
 
+			for _, orphan := range orphans {
+				//删除分叉区块的未扫记录
+				bs.wm.Blockscanner.DeleteUnscanRecord(orphan.Height)
+				//通知分叉区块给观测者，异步处理
+				bs.newBlockNotify(orphan, true)
 			}
 
-			//重置当前区块的hash
-			currentHash = localBlock.Hash
+			//分叉区块中已确认的交易重新等待确认
+			bs.wm.TxTracker.RevertFrom(ancestor.Height + 1)
 
-			bs.wm.Log.Std.Info("rescan block on height: %d, hash: %s .", currentHeight, currentHash)
-
-			//重新记录一个新扫描起点
-			bs.wm.Blockscanner.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
+			//分叉区块中未达到确认数的交易通知回滚
+			bs.revertUnconfirmedBlocks(ancestor.Height + 1)
 
-			isFork = true
+			//分叉后缓存的区块已不可信，丢弃后重新获取
+			pendingBlocks = pendingBlocks[:0]
 
-			if forkBlock != nil {
-				//通知分叉区块给观测者，异步处理
-				bs.newBlockNotify(forkBlock, isFork)
-			}
+			//重置当前区块的高度和hash
+			currentHeight = ancestor.Height
+			currentHash = ancestor.Hash
 
+			continue
 		} else {
 
-			err = bs.BatchExtractTransaction(localBlock.Height, localBlock.Hash, localBlock.Transactions, false)
-			if err != nil {
-				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			if bs.Confirmations == 0 {
+				err = bs.BatchExtractTransaction(localBlock.Height, localBlock.Hash, localBlock.Transactions, false)
+				if err != nil {
+					bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+				}
+			} else {
+				//达到确认数的区块才提取交易，新区块只发送未确认通知
+				bs.extractUnconfirmedBlock(localBlock)
 			}
 
+			//更新已广播交易的确认状态
+			bs.wm.TxTracker.ProcessBlock(localBlock)
+			bs.markMemPoolTxsMined(localBlock)
+
 			//重置当前区块的hash
 			currentHash = localBlock.Hash
 
@@ -209,28 +252,74 @@ func (bs *XBTBlockScanner) ScanBlockTask() {
 			bs.wm.Blockscanner.SaveLocalNewBlock(currentHeight, currentHash)
 			bs.SaveLocalBlock(localBlock)
 
-			isFork = false
+			//提取达到确认数的区块
+			bs.extractConfirmedBlocks(currentHeight)
+
+			bs.metrics.blockScanned(localBlock)
+
 		}
 
 		//通知新区块给观测者，异步处理
-		bs.newBlockNotify(localBlock, isFork)
+		bs.newBlockNotify(localBlock, false)
 	}
 
-	//重扫前N个块，为保证记录找到
-	for i := currentHeight - bs.RescanLastBlockCount; i < currentHeight; i++ {
-		bs.scanBlock(i)
-	}
+	//重新核对前N个块，hash有变化的区块重新提取
+	bs.reverifyLastBlocks(currentHeight)
 
 	if bs.IsScanMemPool {
 		//扫描交易内存池
 		bs.ScanTxMemPool()
 	}
 
+	//超时未上链的交易标记为丢弃
+	bs.wm.TxTracker.CheckTimeout()
+
 	//重扫失败区块
 	bs.RescanFailedRecord()
 
 }
 
+//fetchNextBlocks 获取从start开始的下一批区块，落后较多时按窗口批量获取
+func (bs *XBTBlockScanner) fetchNextBlocks(start, maxHeight uint64) ([]*Block, error) {
+	end := start
+	if bs.BlockRangeSize > 1 && maxHeight > start {
+		end = start + bs.BlockRangeSize - 1
+		if end > maxHeight {
+			end = maxHeight
+		}
+	}
+
+	if end == start {
+		block, err := bs.wm.ApiClient.getBlockByHeight(start)
+		if err != nil {
+			return nil, err
+		}
+		return []*Block{block}, nil
+	}
+
+	bs.wm.Log.Std.Info("block scanner catching up, fetch blocks from height: %d to %d ...", start, end)
+
+	blocks, err := bs.wm.ApiClient.getBlocksByRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	//只保留连续的区块，缺失的高度下一轮再获取
+	result := make([]*Block, 0, len(blocks))
+	for i, block := range blocks {
+		if block.Height != start+uint64(i) {
+			break
+		}
+		result = append(result, block)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("block not found, height : %d", start)
+	}
+
+	return result, nil
+}
+
 //ScanBlock 扫描指定高度区块
 func (bs *XBTBlockScanner) ScanBlock(height uint64) error {
 
@@ -250,8 +339,12 @@ func (bs *XBTBlockScanner) scanBlock(height uint64) (*Block, error) {
 		bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
 		//记录未扫区块
-		unscanRecord := openwallet.NewUnscanRecord(height, "", err.Error(), bs.wm.Symbol())
-		bs.SaveUnscanRecord(unscanRecord)
+		code := UnscanReasonNodeError
+		var notFound *NotFoundError
+		if errors.As(err, &notFound) {
+			code = UnscanReasonBlockNotFound
+		}
+		bs.saveUnscanRecord(height, "", code, err.Error())
 		bs.wm.Log.Std.Info("block height: %d extract failed.", height)
 		return nil, err
 	}
@@ -263,6 +356,8 @@ func (bs *XBTBlockScanner) scanBlock(height uint64) (*Block, error) {
 		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 	}
 
+	bs.wm.TxTracker.ProcessBlock(block)
+
 	return block, nil
 }
 
@@ -278,6 +373,9 @@ func (bs *XBTBlockScanner) ScanTxMemPool() {
 		return
 	}
 
+	//已通知过的交易不重复提取
+	txIDsInMemPool = bs.newMemPoolTxs(txIDsInMemPool)
+
 	if len(txIDsInMemPool) == 0 {
 		bs.wm.Log.Std.Info("no transactions in mempool ...")
 		return
@@ -290,11 +388,13 @@ func (bs *XBTBlockScanner) ScanTxMemPool() {
 
 }
 
-//rescanFailedRecord 重扫失败记录
+//rescanFailedRecord 重扫失败记录，只重扫已到重扫时间且未达到最大失败次数的高度
 func (bs *XBTBlockScanner) RescanFailedRecord() {
 
 	var (
-		blockMap = make(map[uint64][]string)
+		blockMap = make(map[uint64][]*openwallet.UnscanRecord)
+		dueMap   = make(map[uint64]bool)
+		now      = time.Now().Unix()
 	)
 
 	list, err := bs.GetUnscanRecords()
@@ -305,40 +405,29 @@ func (bs *XBTBlockScanner) RescanFailedRecord() {
 	//组合成批处理
 	for _, r := range list {
 
-		if _, exist := blockMap[r.BlockHeight]; !exist {
-			blockMap[r.BlockHeight] = make([]string, 0)
-		}
-
-		if len(r.TxID) > 0 {
-			arr := blockMap[r.BlockHeight]
-			arr = append(arr, r.TxID)
+		blockMap[r.BlockHeight] = append(blockMap[r.BlockHeight], r)
 
-			blockMap[r.BlockHeight] = arr
+		reason := parseUnscanReason(r)
+		if !reason.Dead && reason.NextRetry <= now {
+			dueMap[r.BlockHeight] = true
 		}
 	}
 
-	for height := range blockMap {
+	for height, records := range blockMap {
 
-		if height == 0 {
+		if height == 0 || !dueMap[height] {
 			continue
 		}
 
-		bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", height)
-
-		//block, err := bs.wm.Client.getBlockByHeight(uint64(height))
-		block, err := bs.wm.ApiClient.getBlockByHeight(uint64(height))
-		if err != nil {
-			bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
+		//未达到确认数的区块等待确认后再提取
+		if bs.Confirmations > 0 && height > bs.confirmedHeight {
 			continue
 		}
 
-		err = bs.BatchExtractTransaction(uint64(block.Height), block.Hash, block.Transactions, false)
+		err = bs.rescanHeight(height, records)
 		if err != nil {
-			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-			continue
+			bs.wm.Log.Std.Info("block scanner can not rescan height: %d; unexpected error: %v", height, err)
 		}
-		//删除未扫记录
-		bs.wm.Blockscanner.DeleteUnscanRecord(height)
 	}
 
 	//删除未没有找到交易记录的重扫记录
@@ -361,6 +450,15 @@ func (bs *XBTBlockScanner) newBlockNotify(block *Block, isFork bool) {
 //BatchExtractTransaction 批量提取交易单
 //bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
 func (bs *XBTBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []Transaction, memPool bool) error {
+	if memPool {
+		return bs.batchExtractTransaction(blockHeight, blockHash, txs, memPool, ConfirmStatusMemPool, nil)
+	}
+	return bs.batchExtractTransaction(blockHeight, blockHash, txs, memPool, bs.confirmedStatus(), nil)
+}
+
+//batchExtractTransaction 批量提取交易单，confirmStatus不为空时在通知中标记确认状态
+//redeliver不为nil时，交易只投递给其中记录的观测者及sourceKey，交易对应的值为nil时投递给所有观测者
+func (bs *XBTBlockScanner) batchExtractTransaction(blockHeight uint64, blockHash string, txs []Transaction, memPool bool, confirmStatus string, redeliver map[string]map[string]bool) error {
 
 	var (
 		quit       = make(chan struct{})
@@ -388,17 +486,27 @@ func (bs *XBTBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 
 			if gets.Success {
 
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
+				bs.applyExtParam(gets.extractData, confirmStatus)
+				failedTargets, notifyErr := bs.newExtractDataNotify(height, gets.extractData, redeliver[gets.TxID])
 				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
 					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+					//未确认及回滚的通知失败不记录，由确认后的提取处理
+					if !memPool && confirmStatus != ConfirmStatusUnconfirmed && confirmStatus != ConfirmStatusReverted {
+						bs.saveUnscanRecord(height, gets.TxID, UnscanReasonNotifyFailed, notifyErr.Error(), failedTargets...)
+					}
 				}
+			} else if memPool || confirmStatus == ConfirmStatusUnconfirmed || confirmStatus == ConfirmStatusReverted {
+				//未确认及回滚的通知失败不记录，由确认后的提取处理
+				bs.wm.Log.Std.Info("block height: %d tx: %s extract %s data failed.", height, gets.TxID, confirmStatus)
+				bs.metrics.extractFailed()
+				failed++
 			} else {
 				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
-				bs.SaveUnscanRecord(unscanRecord)
+				bs.saveUnscanRecord(height, gets.TxID, UnscanReasonExtractFailed, gets.Reason)
 				bs.wm.Log.Std.Info("block height: %d extract failed.", height)
+				bs.metrics.extractFailed()
 				failed++ //标记保存失败数
 			}
 			//累计完成的线程数
@@ -520,28 +628,40 @@ func (bs *XBTBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 		return
 	}
 
-	from := trx.From
+	//地址无法解析的交易不属于任何订阅地址，跳过
+	for _, address := range []string{trx.From, trx.To} {
+		if _, err := xbtTransaction.ChecksumAddress(address); err != nil {
+			bs.wm.Log.Std.Error("block height: %d tx: %s has wrong address %q, skip", trx.BlockHeight, trx.TxID, address)
+			return
+		}
+	}
 
-	amountStr := fmt.Sprintf("%d", trx.Amount)
-	toArr := []string{trx.To + ":" + amountStr}
-	trx.ToArr = toArr
+	from := trx.From
 
-	//整数转小数
-	trx.ToDecArr = make([]string, 0)
-	for _, toStr := range trx.ToArr{
-		addr := strings.Split(toStr, ":")[0]
-		amountStr := strings.Split(toStr, ":")[1]
+	//订阅地址为交易单中的发送者
+	accountID1, ok1 := scanTargetFunc(openwallet.ScanTarget{Address: from, Symbol: bs.wm.Symbol(), BalanceModelType: openwallet.BalanceModelTypeAddress})
+	//订阅地址为交易单中的接收者
+	_, ok2 := scanTargetFunc(openwallet.ScanTarget{Address: trx.To, Symbol: bs.wm.Symbol(), BalanceModelType: openwallet.BalanceModelTypeAddress})
 
-		amountInt, _ := strconv.ParseInt(amountStr, 10, 64)
-		amount_dec, _ := decimal.NewFromString(convertToAmount( uint64(amountInt), bs.wm.Decimal()))
-		amount_dec_str := amount_dec.Abs().String()
+	//交易与订阅地址无关时，金额解析失败也无需处理
+	if !ok1 && !ok2 {
+		return
+	}
 
-		trx.ToDecArr = append( trx.ToDecArr, addr+":"+amount_dec_str )
+	//金额解析失败的交易不提取，记录未扫记录等待重扫
+	if len(trx.ParseError) > 0 {
+		bs.wm.Log.Std.Error("block height: %d tx: %s can not be parsed, %s", trx.BlockHeight, trx.TxID, trx.ParseError)
+		result.Success = false
+		result.Reason = trx.ParseError
+		return
 	}
 
-	//订阅地址为交易单中的发送者
-	accountID1, ok1 := scanTargetFunc(openwallet.ScanTarget{Address: from, Symbol: bs.wm.Symbol(), BalanceModelType: openwallet.BalanceModelTypeAddress})
-	//订阅地址为交易单中的接收者
+	toArr := []string{trx.To + ":" + trx.Amount.String()}
+	trx.ToArr = toArr
+
+	//整数转小数
+	trx.ToDecArr = []string{trx.To + ":" + convertToAmount(trx.Amount, bs.wm.Decimal())}
+
 	if ok1 {
 		bs.InitExtractResult(accountID1, trx, result)
 	}
@@ -561,8 +681,7 @@ func (bs *XBTBlockScanner) InitExtractResult(sourceKey string, tx *Transaction,
 	status := "1"
 	reason := ""
 
-	amount_dec, _ := decimal.NewFromString(convertToAmount(tx.Amount, bs.wm.Decimal()))
-	amount := amount_dec.Abs().String()
+	amount := convertToAmount(tx.Amount, bs.wm.Decimal())
 
 	coin := openwallet.Coin{
 		Symbol:     bs.wm.Symbol(),
@@ -603,8 +722,7 @@ func (bs *XBTBlockScanner) InitExtractOutputResult(tx *Transaction, result *Extr
 	status := "1"
 	reason := ""
 
-	amount_dec, _ := decimal.NewFromString(convertToAmount(tx.Amount, bs.wm.Decimal()))
-	amount := amount_dec.Abs().String()
+	amount := convertToAmount(tx.Amount, bs.wm.Decimal())
 
 	coin := openwallet.Coin{
 		Symbol:     bs.wm.Symbol(),
@@ -688,34 +806,42 @@ func (bs *XBTBlockScanner) extractTxInput(trx *Transaction, txExtractData *openw
 	txExtractData.TxInputs = append(txExtractData.TxInputs, txInput)
 }
 
-//newExtractDataNotify 发送通知
-func (bs *XBTBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
+//newExtractDataNotify 发送通知，返回投递失败的观测者及sourceKey
+//only不为nil时只投递给其中记录的观测者及sourceKey
+func (bs *XBTBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData, only map[string]bool) ([]DeliveryTarget, error) {
+
+	var (
+		failedTargets []DeliveryTarget
+		notifyErr     error
+	)
+	registered := make(map[string]bool, len(bs.Observers))
+	for o, _ := range bs.Observers {
+		registered[observerID(o)] = true
+	}
 
 	for o, _ := range bs.Observers {
+		id := observerID(o)
 		for key, data := range extractData {
+			target := DeliveryTarget{Observer: id, SourceKey: key}
+			if !shouldRedeliver(only, target, o, registered) {
+				continue
+			}
+
 			err := o.BlockExtractDataNotify(key, data)
 			if err != nil {
-				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
-				err = bs.SaveUnscanRecord(unscanRecord)
-				if err != nil {
-					bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
-				}
-
+				bs.wm.Log.Std.Error("block height: %d, observer: %s, sourceKey: %s, BlockExtractDataNotify unexpected error: %v", height, id, key, err)
+				failedTargets = append(failedTargets, target)
+				notifyErr = err
 			}
 		}
 	}
 
-	return nil
+	return failedTargets, notifyErr
 }
 
-//DeleteUnscanRecordNotFindTX 删除未没有找到交易记录的重扫记录
+//DeleteUnscanRecordNotFindTX 删除交易已不在该高度区块中的重扫记录，分叉后交易所在的新区块会正常扫描
 func (bs *XBTBlockScanner) DeleteUnscanRecordNotFindTX() error {
 
-	//删除找不到交易单
-	reason := "[-5]No information available about transaction"
-
 	if bs.BlockchainDAI == nil {
 		return fmt.Errorf("Blockchain DAI is not setup ")
 	}
@@ -725,8 +851,28 @@ func (bs *XBTBlockScanner) DeleteUnscanRecordNotFindTX() error {
 		return err
 	}
 
+	blocks := make(map[uint64]map[string]bool)
 	for _, r := range list {
-		if strings.HasPrefix(r.Reason, reason) {
+		if len(r.TxID) == 0 || r.BlockHeight == 0 {
+			continue
+		}
+
+		txids, exist := blocks[r.BlockHeight]
+		if !exist {
+			block, err := bs.wm.ApiClient.getBlockByHeight(r.BlockHeight)
+			if err != nil {
+				//节点暂时无法获取区块时保留记录
+				continue
+			}
+			txids = make(map[string]bool, len(block.Transactions))
+			for _, tx := range block.Transactions {
+				txids[tx.TxID] = true
+			}
+			blocks[r.BlockHeight] = txids
+		}
+
+		if !txids[r.TxID] {
+			bs.wm.Log.Std.Info("block height: %d tx: %s not found, delete unscan record", r.BlockHeight, r.TxID)
 			bs.BlockchainDAI.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
 		}
 	}
@@ -790,7 +936,7 @@ func (bs *XBTBlockScanner) GetCurrentBlockHeader() (*openwallet.BlockHeader, err
 		return nil, err
 	}
 
-	return &openwallet.BlockHeader{Height: blockHeight, Hash: block.Hash}, nil
+	return &openwallet.BlockHeader{Height: blockHeight, Hash: block.Hash, Previousblockhash: block.PrevBlockHash, Time: block.Timestamp}, nil
 }
 
 //GetScannedBlockHeader 获取已扫高度区块头
@@ -976,13 +1122,30 @@ func (bs *XBTBlockScanner) SaveLocalNewBlock(blockHeight uint64, blockHash strin
 	return bs.BlockchainDAI.SaveCurrentBlockHead(header)
 }
 
-//GetTxIDsInMemPool 获取待处理的交易池中的交易单IDs
+//GetTxIDsInMemPool 获取待处理的交易池中的交易单，节点不支持交易池查询时使用本地广播的交易
 func (wm *WalletManager) GetTxIDsInMemPool() ([]Transaction, error) {
-	return nil, nil
+	txs, err := wm.ApiClient.getPendingTransactions()
+	if err != nil {
+		wm.Log.Std.Warning("get pending transactions from node failed, use locally submitted transactions; unexpected error: %v", err)
+		return wm.TxTracker.PendingTransactions(), nil
+	}
+	return txs, nil
 }
 
+//GetTransactionInMemPool 获取交易池中指定的交易单
 func (wm *WalletManager) GetTransactionInMemPool(txid string) (*Transaction, error) {
-	return nil, nil
+	txs, err := wm.GetTxIDsInMemPool()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range txs {
+		if txs[i].TxID == txid {
+			return &txs[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %s not found in mempool", txid)
 }
 
 //GetAssetsAccountBalanceByAddress 查询账户相关地址的交易记录
@@ -1050,7 +1213,7 @@ func (bs *XBTBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet
 		balance := &openwallet.Balance{
 			Symbol:  bs.wm.Symbol(),
 			Address: addr.Address,
-			Balance: convertToAmount(uint64(apiBalance.Balance.Int64()), bs.wm.Decimal()),
+			Balance: convertToAmount(apiBalance.Balance, bs.wm.Decimal()),
 		}
 		addr.Balance = balance
 	}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"encoding/json"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//提取通知的确认状态，保存在Transaction.ExtParam的confirmStatus字段
+const (
+	ConfirmStatusUnconfirmed = "unconfirmed" //区块未达到确认数
+	ConfirmStatusConfirmed   = "confirmed"   //区块已达到确认数
+	ConfirmStatusReverted    = "reverted"    //未确认的区块被分叉回滚
+	ConfirmStatusMemPool     = "mempool"     //交易在交易池中，未打包
+)
+
+//ConfirmExtParam 提取通知中Transaction.ExtParam的内容
+type ConfirmExtParam struct {
+	ConfirmStatus  string `json:"confirmStatus,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey"` //重复投递的通知使用相同的key，用于去重
+}
+
+//confirmedStatus 达到确认数的区块提取时使用的状态，未配置确认数时不标记
+func (bs *XBTBlockScanner) confirmedStatus() string {
+	if bs.Confirmations == 0 {
+		return ""
+	}
+	return ConfirmStatusConfirmed
+}
+
+//initConfirmedHeight 第一次扫描时，认为本地高度减去确认数之前的区块已提取
+func (bs *XBTBlockScanner) initConfirmedHeight(scannedHeight uint64) {
+	if bs.Confirmations == 0 || bs.confirmedInited {
+		return
+	}
+
+	if scannedHeight > bs.Confirmations {
+		bs.confirmedHeight = scannedHeight - bs.Confirmations
+		bs.confirmedInited = true
+	}
+}
+
+//resetConfirmedHeight 扫描高度回退到height时，已提取的高度回退到height，并移除之后缓存的未确认区块
+func (bs *XBTBlockScanner) resetConfirmedHeight(height uint64) {
+	if !bs.confirmedInited || bs.confirmedHeight > height {
+		bs.confirmedHeight = height
+	}
+	bs.confirmedInited = true
+
+	for h := range bs.unconfirmedBlocks {
+		if h > height {
+			delete(bs.unconfirmedBlocks, h)
+		}
+	}
+}
+
+//applyExtParam 在提取结果中标记幂等key、确认状态及确认数
+func (bs *XBTBlockScanner) applyExtParam(extractData map[string]*openwallet.TxExtractData, confirmStatus string) {
+	for sourceKey, data := range extractData {
+		if data.Transaction == nil {
+			continue
+		}
+
+		//同一交易的多个sourceKey共用Transaction，复制后再修改
+		tx := *data.Transaction
+		extParam, _ := json.Marshal(ConfirmExtParam{
+			ConfirmStatus:  confirmStatus,
+			IdempotencyKey: bs.idempotencyKey(sourceKey, &tx, confirmStatus),
+		})
+		tx.ExtParam = string(extParam)
+		switch confirmStatus {
+		case ConfirmStatusConfirmed:
+			tx.Confirm = int64(bs.Confirmations)
+		case ConfirmStatusReverted:
+			tx.Confirm = 0
+			tx.Status = openwallet.TxStatusFail
+			tx.Reason = "block reverted"
+		case ConfirmStatusUnconfirmed, ConfirmStatusMemPool:
+			tx.Confirm = 0
+		}
+		data.Transaction = &tx
+	}
+}
+
+//extractUnconfirmedBlock 记录未达到确认数的区块，开启NotifyUnconfirmed时发送未确认通知
+func (bs *XBTBlockScanner) extractUnconfirmedBlock(block *Block) {
+	bs.unconfirmedBlocks[block.Height] = block
+
+	if !bs.NotifyUnconfirmed || len(block.Transactions) == 0 {
+		return
+	}
+
+	err := bs.batchExtractTransaction(block.Height, block.Hash, block.Transactions, false, ConfirmStatusUnconfirmed, nil)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not extract unconfirmed records; unexpected error: %v", err)
+	}
+}
+
+//extractConfirmedBlocks 提取高度在scannedHeight减去确认数之前，且未提取过的区块
+func (bs *XBTBlockScanner) extractConfirmedBlocks(scannedHeight uint64) {
+	if bs.Confirmations == 0 {
+		return
+	}
+
+	for height := bs.confirmedHeight + 1; height+bs.Confirmations <= scannedHeight; height++ {
+
+		localBlock, err := bs.GetLocalBlock(height)
+		if err != nil {
+			bs.wm.Log.Std.Error("block scanner can not get local block %d; unexpected error: %v", height, err)
+			return
+		}
+
+		//优先使用扫描时缓存的区块，hash不一致时重新获取
+		block := bs.unconfirmedBlocks[height]
+		if block == nil || block.Hash != localBlock.Hash {
+			block, err = bs.wm.ApiClient.getBlockByHeight(height)
+			if err != nil {
+				bs.wm.Log.Std.Info("block scanner can not get confirmed block %d; unexpected error: %v", height, err)
+				return
+			}
+			if block.Hash != localBlock.Hash {
+				//节点的区块与本地不一致，等待分叉处理后再提取
+				bs.wm.Log.Std.Warning("block %d hash %s mismatch local hash %s, wait for fork handling", height, block.Hash, localBlock.Hash)
+				return
+			}
+		}
+
+		if len(block.Transactions) > 0 {
+			err = bs.batchExtractTransaction(block.Height, block.Hash, block.Transactions, false, ConfirmStatusConfirmed, nil)
+			if err != nil {
+				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			}
+		}
+
+		delete(bs.unconfirmedBlocks, height)
+		bs.confirmedHeight = height
+	}
+}
+
+//revertUnconfirmedBlocks 分叉时，height及之后未达到确认数的区块发送回滚通知
+func (bs *XBTBlockScanner) revertUnconfirmedBlocks(height uint64) {
+	if bs.Confirmations == 0 {
+		return
+	}
+
+	for h, block := range bs.unconfirmedBlocks {
+		if h < height {
+			continue
+		}
+
+		if bs.NotifyUnconfirmed && len(block.Transactions) > 0 {
+			bs.wm.Log.Std.Info("block scanner revert unconfirmed block height: %d, hash: %s", h, block.Hash)
+			err := bs.batchExtractTransaction(block.Height, block.Hash, block.Transactions, false, ConfirmStatusReverted, nil)
+			if err != nil {
+				bs.wm.Log.Std.Info("block scanner can not extract reverted records; unexpected error: %v", err)
+			}
+		}
+
+		delete(bs.unconfirmedBlocks, h)
+	}
+
+	//分叉深度超过确认数时，已提取的高度回退，按新链重新提取
+	if height > 0 && bs.confirmedHeight >= height {
+		bs.wm.Log.Std.Warning("fork at height %d is deeper than confirmations %d", height, bs.Confirmations)
+		bs.confirmedHeight = height - 1
+	}
+}
@@ -88,6 +88,7 @@ func (bs *XBTBlockScanner) GetLocalBlock(height uint64) (*Block, error) {
 		Hash:          header.Hash,
 		Height:        header.Height,
 		PrevBlockHash: header.Previousblockhash,
+		Timestamp:     header.Time,
 	}
 
 	return block, nil
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//IdentifiableObserver 观测者可选实现的接口，返回重启后不变的唯一标识，用于记录投递失败的观测者
+//未实现时使用观测者的类型名及指针地址，重启后地址变化的记录会重新投递给同一类型的所有观测者
+type IdentifiableObserver interface {
+	ObserverID() string
+}
+
+//DeliveryTarget 投递失败的观测者及sourceKey
+type DeliveryTarget struct {
+	Observer  string `json:"observer"`
+	SourceKey string `json:"sourceKey"`
+}
+
+func (t DeliveryTarget) key() string {
+	return t.Observer + "|" + t.SourceKey
+}
+
+//observerID 观测者的标识，同一类型的多个观测者按指针地址区分
+func observerID(o openwallet.BlockScanNotificationObject) string {
+	if obj, ok := o.(IdentifiableObserver); ok {
+		return obj.ObserverID()
+	}
+	if reflect.ValueOf(o).Kind() == reflect.Ptr {
+		return fmt.Sprintf("%T@%p", o, o)
+	}
+	return fmt.Sprintf("%T", o)
+}
+
+//shouldRedeliver 重扫时是否投递给该观测者及sourceKey，only为nil时全部投递
+//记录中的观测者已不存在（重启后指针地址变化）时，投递给同一类型的观测者，重复的通知由idempotencyKey去重
+func shouldRedeliver(only map[string]bool, target DeliveryTarget, o openwallet.BlockScanNotificationObject, registered map[string]bool) bool {
+	if only == nil || only[target.key()] {
+		return true
+	}
+	if _, ok := o.(IdentifiableObserver); ok {
+		return false
+	}
+
+	typeName := fmt.Sprintf("%T", o)
+	for key := range only {
+		i := strings.Index(key, "|")
+		if i < 0 || key[i+1:] != target.SourceKey {
+			continue
+		}
+		recorded := key[:i]
+		if !registered[recorded] && strings.SplitN(recorded, "@", 2)[0] == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+//idempotencyKey 同一交易对同一sourceKey以相同确认状态通知时的唯一标识，重复投递时不变
+func (bs *XBTBlockScanner) idempotencyKey(sourceKey string, tx *openwallet.Transaction, confirmStatus string) string {
+	data := fmt.Sprintf("%s_%s_%s_%s_%s", bs.wm.Symbol(), sourceKey, tx.TxID, tx.BlockHash, confirmStatus)
+	return hex.EncodeToString(owcrypt.Hash([]byte(data), 0, owcrypt.HASH_ALG_SHA256))
+}
+
+//redeliveryFilter 根据未扫记录计算重扫时需要投递的交易及观测者
+//返回nil表示整个区块重新投递；交易对应的值为nil表示该交易投递给所有观测者
+func redeliveryFilter(records []*openwallet.UnscanRecord) map[string]map[string]bool {
+	filter := make(map[string]map[string]bool)
+	for _, r := range records {
+		reason := parseUnscanReason(r)
+
+		if reason.Code == UnscanReasonNotifyFailed && len(reason.Targets) > 0 {
+			if _, exist := filter[r.TxID]; exist && filter[r.TxID] == nil {
+				continue
+			}
+			if filter[r.TxID] == nil {
+				filter[r.TxID] = make(map[string]bool)
+			}
+			for _, t := range reason.Targets {
+				filter[r.TxID][t.key()] = true
+			}
+			continue
+		}
+
+		//没有交易的记录表示整个区块未完成
+		if len(r.TxID) == 0 {
+			return nil
+		}
+		filter[r.TxID] = nil
+	}
+	return filter
+}
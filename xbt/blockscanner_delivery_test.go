@@ -0,0 +1,253 @@
+package xbt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+//testNamedObserver 实现IdentifiableObserver的观测者
+type testNamedObserver struct {
+	*testFailingObserver
+	id string
+}
+
+func (o *testNamedObserver) ObserverID() string {
+	return o.id
+}
+
+func idempotencyKeyOf(data *openwallet.TxExtractData) string {
+	var param ConfirmExtParam
+	json.Unmarshal([]byte(data.Transaction.ExtParam), &param)
+	return param.IdempotencyKey
+}
+
+func TestRedeliveryFilter(t *testing.T) {
+	reason := func(r UnscanReason) string {
+		data, _ := json.Marshal(r)
+		return string(data)
+	}
+	target := DeliveryTarget{Observer: "a", SourceKey: "k"}
+
+	filter := redeliveryFilter([]*openwallet.UnscanRecord{
+		{BlockHeight: 1, TxID: "tx1", Reason: reason(UnscanReason{Code: UnscanReasonNotifyFailed, Targets: []DeliveryTarget{target}})},
+		{BlockHeight: 1, TxID: "tx2", Reason: reason(UnscanReason{Code: UnscanReasonExtractFailed})},
+		{BlockHeight: 1, TxID: "tx3", Reason: "ExtractData Notify failed."},
+	})
+	if len(filter) != 3 || !filter["tx1"][target.key()] || filter["tx2"] != nil || filter["tx3"] != nil {
+		t.Errorf("unexpected filter: %+v", filter)
+	}
+
+	//没有交易的记录需要重新投递整个区块
+	filter = redeliveryFilter([]*openwallet.UnscanRecord{
+		{BlockHeight: 1, TxID: "tx1", Reason: reason(UnscanReason{Code: UnscanReasonNotifyFailed, Targets: []DeliveryTarget{target}})},
+		{BlockHeight: 1, Reason: reason(UnscanReason{Code: UnscanReasonNodeError})},
+	})
+	if filter != nil {
+		t.Errorf("filter should be nil, got %+v", filter)
+	}
+}
+
+func TestXBTBlockScanner_Redelivery_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1"},
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "2"},
+	)
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	good := newTestMockObserver()
+	flaky := &testNamedObserver{
+		testFailingObserver: &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 1},
+		id:                  "flaky",
+	}
+	bs.AddObserver(good)
+	bs.AddObserver(flaky)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	if n := len(good.extractData(testAccountID)); n != 2 {
+		t.Fatalf("good observer received %d records, want 2", n)
+	}
+	if n := len(flaky.extractData(testAccountID)); n != 1 {
+		t.Fatalf("flaky observer received %d records, want 1", n)
+	}
+
+	infos, _ := bs.GetUnscanRecordInfos(false)
+	if len(infos) != 1 || infos[0].Code != UnscanReasonNotifyFailed || len(infos[0].TxID) == 0 ||
+		len(infos[0].Targets) != 1 || infos[0].Targets[0] != (DeliveryTarget{Observer: "flaky", SourceKey: testAccountID}) {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+
+	//重扫只投递给失败的观测者
+	if err := bs.RetryUnscanRecord(2); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(good.extractData(testAccountID)); n != 2 {
+		t.Errorf("good observer received %d records after retry, want 2", n)
+	}
+	redelivered := flaky.extractData(testAccountID)
+	if len(redelivered) != 2 || redelivered[1].Transaction.TxID != infos[0].TxID {
+		t.Fatalf("flaky observer did not receive the failed record: %+v", redelivered)
+	}
+
+	//重新投递的通知与其他观测者收到的通知使用相同的幂等key
+	keys := make(map[string]string)
+	for _, d := range good.extractData(testAccountID) {
+		keys[d.Transaction.TxID] = idempotencyKeyOf(d)
+	}
+	for _, d := range redelivered {
+		key := idempotencyKeyOf(d)
+		if len(key) == 0 || key != keys[d.Transaction.TxID] {
+			t.Errorf("tx %s idempotency key %q, want %q", d.Transaction.TxID, key, keys[d.Transaction.TxID])
+		}
+	}
+	if keys[redelivered[0].Transaction.TxID] == keys[redelivered[1].Transaction.TxID] {
+		t.Error("different txs should have different idempotency keys")
+	}
+}
+
+func TestXBTBlockScanner_RedeliveryPartialFailure_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1"},
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "2"},
+	)
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	//两个观测者首次扫描都失败，重扫时b再失败一次
+	a := &testNamedObserver{
+		testFailingObserver: &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 2},
+		id:                  "a",
+	}
+	b := &testNamedObserver{
+		testFailingObserver: &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 3},
+		id:                  "b",
+	}
+	bs.AddObserver(a)
+	bs.AddObserver(b)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	infos, _ := bs.GetUnscanRecordInfos(false)
+	if len(infos) != 2 || len(infos[0].Targets) != 2 || len(infos[1].Targets) != 2 {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+
+	if err := bs.RetryUnscanRecord(2); err == nil {
+		t.Fatal("expected error when observer b fails again")
+	}
+	if n := len(a.extractData(testAccountID)); n != 2 {
+		t.Fatalf("observer a received %d records, want 2", n)
+	}
+	delivered := b.extractData(testAccountID)
+	if len(delivered) != 1 {
+		t.Fatalf("observer b received %d records, want 1", len(delivered))
+	}
+
+	//只保留b投递失败的交易，累计该记录的失败次数
+	infos, _ = bs.GetUnscanRecordInfos(false)
+	if len(infos) != 1 || infos[0].TxID == delivered[0].Transaction.TxID || infos[0].Attempts != 2 ||
+		len(infos[0].Targets) != 1 || infos[0].Targets[0] != (DeliveryTarget{Observer: "b", SourceKey: testAccountID}) {
+		t.Fatalf("unexpected unscan records after retry: %+v", infos)
+	}
+
+	//再次重扫只投递给b
+	if err := bs.RetryUnscanRecord(2); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(a.extractData(testAccountID)); n != 2 {
+		t.Errorf("observer a received %d records, want 2", n)
+	}
+	delivered = b.extractData(testAccountID)
+	if len(delivered) != 2 || delivered[1].Transaction.TxID != infos[0].TxID {
+		t.Errorf("observer b did not receive the failed record: %+v", delivered)
+	}
+	if infos, _ := bs.GetUnscanRecordInfos(false); len(infos) != 0 {
+		t.Errorf("unscan records should be deleted, got %+v", infos)
+	}
+}
+
+func TestXBTBlockScanner_RedeliverySameType_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	//同一类型的两个观测者，只有flaky投递失败
+	good := &testFailingObserver{testMockObserver: newTestMockObserver()}
+	flaky := &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 1}
+	bs.AddObserver(good)
+	bs.AddObserver(flaky)
+	if observerID(good) == observerID(flaky) {
+		t.Fatalf("observers of the same type have the same id: %s", observerID(good))
+	}
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	infos, _ := bs.GetUnscanRecordInfos(false)
+	if len(infos) != 1 || len(infos[0].Targets) != 1 || infos[0].Targets[0].Observer != observerID(flaky) {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+
+	if err := bs.RetryUnscanRecord(2); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(good.extractData(testAccountID)); n != 1 {
+		t.Errorf("good observer received %d records, want 1", n)
+	}
+	if n := len(flaky.extractData(testAccountID)); n != 1 {
+		t.Errorf("flaky observer received %d records, want 1", n)
+	}
+}
+
+func TestShouldRedeliver(t *testing.T) {
+	current := &testFailingObserver{testMockObserver: newTestMockObserver()}
+	named := &testNamedObserver{testFailingObserver: current, id: "named"}
+	registered := map[string]bool{observerID(current): true, "named": true}
+	target := DeliveryTarget{Observer: observerID(current), SourceKey: "k"}
+
+	//重启前记录的观测者地址已不存在时，投递给同一类型的观测者
+	stale := DeliveryTarget{Observer: "*xbt.testFailingObserver@0xc000000001", SourceKey: "k"}
+	tests := []struct {
+		name string
+		only map[string]bool
+		o    openwallet.BlockScanNotificationObject
+		want bool
+	}{
+		{"all", nil, current, true},
+		{"recorded", map[string]bool{target.key(): true}, current, true},
+		{"other observer", map[string]bool{(DeliveryTarget{Observer: "named", SourceKey: "k"}).key(): true}, current, false},
+		{"stale same type", map[string]bool{stale.key(): true}, current, true},
+		{"stale other sourceKey", map[string]bool{(DeliveryTarget{Observer: stale.Observer, SourceKey: "x"}).key(): true}, current, false},
+		{"stale identifiable", map[string]bool{(DeliveryTarget{Observer: "old", SourceKey: "k"}).key(): true}, named, false},
+	}
+	for _, tt := range tests {
+		target := DeliveryTarget{Observer: observerID(tt.o), SourceKey: "k"}
+		if got := shouldRedeliver(tt.only, target, tt.o, registered); got != tt.want {
+			t.Errorf("%s: shouldRedeliver = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+const memPoolTxMined = "mined"
+
+//newMemPoolTxs 过滤出未通知过且未被打包的交易池交易，已离开交易池的交易不再记录
+func (bs *XBTBlockScanner) newMemPoolTxs(txs []Transaction) []Transaction {
+	bs.memPoolMu.Lock()
+	defer bs.memPoolMu.Unlock()
+
+	current := make(map[string]bool, len(txs))
+	result := make([]Transaction, 0, len(txs))
+	for _, tx := range txs {
+		current[tx.TxID] = true
+		if _, exist := bs.memPoolTxs[tx.TxID]; !exist {
+			result = append(result, tx)
+		}
+	}
+
+	//已离开交易池（被打包或丢弃）的交易
+	for txid := range bs.memPoolTxs {
+		if !current[txid] {
+			delete(bs.memPoolTxs, txid)
+		}
+	}
+
+	for _, tx := range result {
+		bs.memPoolTxs[tx.TxID] = ConfirmStatusMemPool
+	}
+
+	return result
+}
+
+//markMemPoolTxsMined 交易被打包后标记为已打包，节点交易池未及时更新时也不再作为交易池交易通知
+func (bs *XBTBlockScanner) markMemPoolTxsMined(block *Block) {
+	if !bs.IsScanMemPool {
+		return
+	}
+
+	bs.memPoolMu.Lock()
+	defer bs.memPoolMu.Unlock()
+
+	for _, tx := range block.Transactions {
+		bs.memPoolTxs[tx.TxID] = memPoolTxMined
+	}
+}
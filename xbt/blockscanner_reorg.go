@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"fmt"
+
+	"github.com/asdine/storm"
+)
+
+const defaultMaxReorgDepth = 100
+
+//findCommonAncestor 从本地已扫高度往回查找与节点hash一致的区块，返回共同祖先及被分叉的本地区块（高度从高到低）
+//超过MaxReorgDepth仍未找到时告警并返回错误，扫描停在原高度等待人工处理
+func (bs *XBTBlockScanner) findCommonAncestor(headHeight uint64, headHash string) (*Block, []*Block, error) {
+	orphans := make([]*Block, 0)
+	height := headHeight
+
+	for height > 0 {
+
+		//按窗口批量获取节点的区块
+		start := height
+		if bs.BlockRangeSize > 1 {
+			start = 1
+			if height > bs.BlockRangeSize {
+				start = height - bs.BlockRangeSize + 1
+			}
+		}
+
+		remoteBlocks, err := bs.wm.ApiClient.getBlocksByRange(start, height)
+		if err != nil {
+			return nil, nil, err
+		}
+		remotes := make(map[uint64]*Block, len(remoteBlocks))
+		for _, b := range remoteBlocks {
+			remotes[b.Height] = b
+		}
+
+		for ; height >= start; height-- {
+			remote, ok := remotes[height]
+			if !ok {
+				return nil, nil, fmt.Errorf("block %d not found on node", height)
+			}
+
+			local, err := bs.GetLocalBlock(height)
+			if err == storm.ErrNotFound {
+				if height != headHeight {
+					//本地没有保存该区块，无法继续比对，以节点的区块为共同祖先
+					bs.wm.Log.Std.Warning("local block %d not found, use node block %s as common ancestor", height, remote.Hash)
+					return remote, orphans, nil
+				}
+				local = &Block{Height: height, Hash: headHash}
+			} else if err != nil {
+				return nil, nil, err
+			}
+
+			if local.Hash == remote.Hash {
+				return remote, orphans, nil
+			}
+
+			orphans = append(orphans, local)
+
+			if bs.MaxReorgDepth > 0 && uint64(len(orphans)) > bs.MaxReorgDepth {
+				bs.reorgAlert(headHeight)
+				return nil, nil, fmt.Errorf("reorg from height %d exceeds max depth %d", headHeight, bs.MaxReorgDepth)
+			}
+
+			if height == 1 {
+				break
+			}
+		}
+
+		if height <= 1 {
+			break
+		}
+	}
+
+	return nil, nil, fmt.Errorf("common ancestor of height %d not found", headHeight)
+}
+
+//reorgAlert 分叉深度超过上限时告警
+func (bs *XBTBlockScanner) reorgAlert(headHeight uint64) {
+	bs.wm.Log.Std.Alert("block reorg from height %d is deeper than %d blocks, scanner stopped at local height, please check the node and rescan manually", headHeight, bs.MaxReorgDepth)
+
+	if bs.ReorgAlertFunc != nil {
+		bs.ReorgAlertFunc(headHeight, bs.MaxReorgDepth)
+	}
+}
+
+//reverifyLastBlocks 重新获取已扫高度之前的RescanLastBlockCount个区块，与本地保存的区块hash比对，
+//hash有变化的区块更新本地记录并重新提取，hash一致的区块不重复通知
+func (bs *XBTBlockScanner) reverifyLastBlocks(scannedHeight uint64) {
+	if bs.RescanLastBlockCount == 0 || scannedHeight <= 1 {
+		return
+	}
+
+	end := scannedHeight - 1
+	start := uint64(1)
+	if end > bs.RescanLastBlockCount {
+		start = end - bs.RescanLastBlockCount + 1
+	}
+
+	blocks, err := bs.wm.ApiClient.getBlocksByRange(start, end)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not get blocks to reverify; unexpected error: %v", err)
+		return
+	}
+
+	for _, block := range blocks {
+		local, err := bs.GetLocalBlock(block.Height)
+		if err != nil {
+			//本地没有保存的区块无法比对
+			continue
+		}
+
+		if local.Hash == block.Hash {
+			continue
+		}
+
+		bs.wm.Log.Std.Warning("block height: %d hash changed from %s to %s, extract again", block.Height, local.Hash, block.Hash)
+		bs.metrics.forked()
+
+		bs.SaveLocalBlock(block)
+		bs.DeleteUnscanRecord(block.Height)
+		bs.newBlockNotify(local, true)
+
+		//原区块中已确认的交易重新等待确认
+		bs.wm.TxTracker.RevertBlock(local.Hash)
+		bs.wm.TxTracker.ProcessBlock(block)
+
+		if bs.Confirmations > 0 && block.Height > bs.confirmedHeight {
+			//未达到确认数的区块，回滚原区块的未确认通知，等待确认后提取
+			if old := bs.unconfirmedBlocks[block.Height]; old != nil && bs.NotifyUnconfirmed && len(old.Transactions) > 0 {
+				bs.batchExtractTransaction(old.Height, old.Hash, old.Transactions, false, ConfirmStatusReverted, nil)
+			}
+			bs.extractUnconfirmedBlock(block)
+		} else if len(block.Transactions) > 0 {
+			err = bs.BatchExtractTransaction(block.Height, block.Hash, block.Transactions, false)
+			if err != nil {
+				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+			}
+		}
+
+		bs.newBlockNotify(block, false)
+	}
+}
@@ -0,0 +1,640 @@
+package xbt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	testDepositAddress = "xB1CE3Ff24Bbe10dc457320D0BB3602d5C79F844a5"
+	testOtherAddress   = "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B"
+	testAccountID      = "testAccount"
+)
+
+//testMockObserver 记录扫描器的通知
+type testMockObserver struct {
+	mu      sync.Mutex
+	headers []*openwallet.BlockHeader
+	data    map[string][]*openwallet.TxExtractData
+}
+
+func newTestMockObserver() *testMockObserver {
+	return &testMockObserver{data: make(map[string][]*openwallet.TxExtractData)}
+}
+
+func (o *testMockObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.headers = append(o.headers, header)
+	return nil
+}
+
+func (o *testMockObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.data[sourceKey] = append(o.data[sourceKey], data)
+	return nil
+}
+
+func (o *testMockObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	return nil
+}
+
+func (o *testMockObserver) extractData(sourceKey string) []*openwallet.TxExtractData {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*openwallet.TxExtractData{}, o.data[sourceKey]...)
+}
+
+//testMockWalletManager 创建连接模拟节点的钱包管理器，区块数据保存在临时目录，extra为追加的配置行
+func testMockWalletManager(t *testing.T, srv *xbtmock.Server, extra ...string) *WalletManager {
+	dir, err := ioutil.TempDir("", "xbt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ini := "serverAPI = \"" + srv.URL + "\"\n" +
+		"xbtToolsAPI = \"" + srv.URL + "\"\n" +
+		"fixedFee = \"0.1\"\n" +
+		"dataDir = \"" + filepath.Join(dir, "data") + "\"\n" +
+		strings.Join(extra, "\n")
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatal(err)
+	}
+
+	dai, err := openwallet.NewBlockchainLocal(filepath.Join(dir, "blockchain.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm.Blockscanner.SetBlockchainDAI(dai)
+	wm.Blockscanner.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		if target.Address == testDepositAddress {
+			return testAccountID, true
+		}
+		return "", false
+	})
+	wm.Blockscanner.Scanning = true
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	return wm
+}
+
+func TestXBTBlockScanner_ScanBlockTask_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(3)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1.25"})
+	srv.AddBlocks(30)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+
+	bs.ScanBlockTask()
+
+	if h := bs.GetScannedBlockHeight(); h != srv.Height() {
+		t.Fatalf("scanned height = %d, want %d", h, srv.Height())
+	}
+
+	//追块模式下按窗口批量获取区块
+	if n := srv.Requests(xbtmock.PathBlockRange); n > 3 {
+		t.Errorf("block range requested %d times, expected batched requests", n)
+	}
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 {
+		t.Fatalf("deposits = %d, want 1", len(deposits))
+	}
+	if len(deposits[0].TxOutputs) != 1 || deposits[0].TxOutputs[0].Amount != "1.25" || deposits[0].TxOutputs[0].BlockHeight != 4 {
+		t.Errorf("unexpected deposit: %+v", deposits[0].TxOutputs[0])
+	}
+}
+
+func TestXBTBlockScanner_Fork_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(5)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	//第5个区块被替换
+	srv.Fork(5)
+	srv.AddBlocks(2)
+
+	bs.ScanBlockTask()
+
+	height, hash, _ := bs.GetLocalNewBlock()
+	if height != srv.Height() || hash != srv.GetBlock(height).Hash {
+		t.Errorf("scanner did not follow the new chain, height = %d, hash = %s", height, hash)
+	}
+}
+
+//testSignedTx 用测试私钥签名一笔交易，返回交易及发送地址
+func testSignedTx(t *testing.T, to, amountStr string) (*xbtTransaction.TxStruct, string) {
+	prikey, _ := hex.DecodeString("4f3edf983ac636a65a842ce7c78d9aa706d3b113bce9c46f30d7d21715b23b1d")
+	pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	from, _ := xbtTransaction.GetAddressByPublicKey(pubkey)
+
+	amount, _ := decimal.NewFromString(amountStr)
+	fee, _ := decimal.NewFromString("0.1")
+	txStruct, message, _ := xbtTransaction.GetTxStruct(to, &amount, &fee)
+	signature, _ := xbtTransaction.SignTransaction(hex.EncodeToString(message), prikey)
+	signedTrans, err := xbtTransaction.VerifyAndCombineTransaction(txStruct.ToJSONString(), hex.EncodeToString(signature), pubkey, from)
+	if err != nil {
+		t.Fatalf("VerifyAndCombineTransaction failed: %v", err)
+	}
+	ts, _ := xbtTransaction.NewTxStructFromJSON(signedTrans)
+	return ts, from
+}
+
+func TestClient_SendTransaction_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	ts, from := testSignedTx(t, testDepositAddress, "0.5")
+
+	c := NewClient(srv.URL, false, symbol, currencyDecimal)
+	txid, err := c.sendTransaction(ts)
+	if err != nil {
+		t.Fatalf("sendTransaction failed: %v", err)
+	}
+	if txid != ts.Hash || len(srv.Submitted()) != 1 {
+		t.Errorf("unexpected submit result, txid = %s", txid)
+	}
+
+	block := srv.MineSubmitted()
+	if len(block.Txs) != 1 || block.Txs[0].From != from || block.Txs[0].Hash != ts.Hash {
+		t.Errorf("unexpected mined block: %+v", block)
+	}
+}
+
+func TestXBTBlockScanner_BlockTime_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.TimeInMillis = true
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "2"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	//节点返回毫秒，统一保存为秒
+	want := srv.GetBlock(2).Time
+	local, err := bs.GetLocalBlock(2)
+	if err != nil {
+		t.Fatalf("GetLocalBlock failed: %v", err)
+	}
+	if local.Timestamp != want || local.BlockHeader().Time != want {
+		t.Errorf("local block time = %d, want %d", local.Timestamp, want)
+	}
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 || deposits[0].Transaction.ConfirmTime != int64(want) {
+		t.Errorf("unexpected confirm time in deposits: %+v", deposits)
+	}
+}
+
+func TestXBTBlockScanner_Amount_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "98765432109876.543210"},
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1.1234567"},
+	)
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	//大额交易不丢失精度，超出精度的交易不提取；未扫记录重扫时会再次通知正常的交易
+	bad := srv.GetBlock(2).Txs[1]
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) == 0 {
+		t.Fatal("no deposit found")
+	}
+	for _, d := range deposits {
+		if d.Transaction.TxID == bad.Hash || d.TxOutputs[0].Amount != "98765432109876.54321" {
+			t.Errorf("unexpected deposit: %+v", d.TxOutputs[0])
+		}
+	}
+
+	//超出精度的交易记录未扫记录
+	records, err := bs.GetUnscanRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range records {
+		if r.BlockHeight == 2 && r.TxID == bad.Hash && len(r.Reason) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("unscan record of tx %s not found in %+v", bad.Hash, records)
+	}
+}
+
+func TestXBTBlockScanner_ParseErrorUnrelated_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	unrelated, _ := xbtTransaction.ChecksumAddress("xb" + strings.Repeat("12", 20))
+	srv.AddBlocks(1)
+	srv.AddBlock(
+		xbtmock.Tx{From: testOtherAddress, To: unrelated, Amount: "1.1234567"},
+		xbtmock.Tx{From: "wrong", To: testDepositAddress, Amount: "1"},
+		xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "2"},
+	)
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	//与订阅地址无关的交易金额解析失败、地址无法解析的交易都跳过，不记录未扫记录
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 || deposits[0].Transaction.TxID != srv.GetBlock(2).Txs[2].Hash {
+		t.Fatalf("unexpected deposits: %d", len(deposits))
+	}
+	if records, _ := bs.GetUnscanRecords(); len(records) != 0 {
+		t.Errorf("unexpected unscan records: %+v", records)
+	}
+	if bs.GetScannedBlockHeight() != 3 {
+		t.Errorf("scanned height = %d, want 3", bs.GetScannedBlockHeight())
+	}
+}
+
+//confirmStatusOf 提取通知中的确认状态
+func confirmStatusOf(data *openwallet.TxExtractData) string {
+	var param ConfirmExtParam
+	json.Unmarshal([]byte(data.Transaction.ExtParam), &param)
+	return param.ConfirmStatus
+}
+
+func TestXBTBlockScanner_Confirmations_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(3)
+	deposit := srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "3"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv, "confirmations = 2")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+
+	//区块4只有1个确认，不提取
+	bs.ScanBlockTask()
+	if n := len(observer.extractData(testAccountID)); n != 0 {
+		t.Fatalf("deposits = %d before confirmations reached, want 0", n)
+	}
+
+	srv.AddBlocks(1)
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 {
+		t.Fatalf("deposits = %d, want 1", len(deposits))
+	}
+	if deposits[0].Transaction.TxID != deposit.Txs[0].Hash || confirmStatusOf(deposits[0]) != ConfirmStatusConfirmed || deposits[0].Transaction.Confirm != 2 {
+		t.Errorf("unexpected deposit: %+v", deposits[0].Transaction)
+	}
+}
+
+func TestXBTBlockScanner_RescanConfirmed_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(3)
+	deposit := srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "3"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv, "confirmations = 2")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+	srv.AddBlocks(1)
+	bs.ScanBlockTask()
+	if n := len(observer.extractData(testAccountID)); n != 1 {
+		t.Fatalf("deposits = %d, want 1", n)
+	}
+
+	//重扫已确认的区块，重新发送确认通知
+	if err := bs.SetRescanBlockHeight(deposit.Height); err != nil {
+		t.Fatalf("SetRescanBlockHeight failed: %v", err)
+	}
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 2 {
+		t.Fatalf("deposits = %d after rescan, want 2", len(deposits))
+	}
+	if deposits[1].Transaction.TxID != deposit.Txs[0].Hash || confirmStatusOf(deposits[1]) != ConfirmStatusConfirmed {
+		t.Errorf("unexpected rescanned deposit: %+v", deposits[1].Transaction)
+	}
+}
+
+func TestXBTBlockScanner_UnconfirmedReverted_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(4)
+	deposit := srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "3"})
+
+	wm := testMockWalletManager(t, srv, "confirmations = 3", "notifyUnconfirmed = true")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 || confirmStatusOf(deposits[0]) != ConfirmStatusUnconfirmed {
+		t.Fatalf("expected one unconfirmed deposit, got %+v", deposits)
+	}
+
+	//包含充值的区块被分叉替换
+	srv.Fork(5)
+	srv.AddBlocks(4)
+	bs.ScanBlockTask()
+
+	deposits = observer.extractData(testAccountID)
+	statuses := make([]string, 0)
+	for _, d := range deposits {
+		if d.Transaction.TxID != deposit.Txs[0].Hash {
+			t.Errorf("unexpected deposit tx: %s", d.Transaction.TxID)
+		}
+		statuses = append(statuses, confirmStatusOf(d))
+	}
+	if len(statuses) != 2 || statuses[1] != ConfirmStatusReverted || deposits[1].Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("unexpected notifications: %v", statuses)
+	}
+}
+
+func TestXBTBlockScanner_DeepReorg_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(10)
+
+	wm := testMockWalletManager(t, srv, "blockRangeSize = 3")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	bs.SaveUnscanRecord(openwallet.NewUnscanRecord(7, "", "test", bs.wm.Symbol()))
+
+	//第6个区块之后全部被替换
+	srv.Fork(6)
+	srv.AddBlocks(7)
+
+	bs.ScanBlockTask()
+
+	height, hash, _ := bs.GetLocalNewBlock()
+	if height != srv.Height() || hash != srv.GetBlock(height).Hash {
+		t.Fatalf("scanner did not follow the new chain, height = %d, hash = %s", height, hash)
+	}
+
+	//每个被分叉的区块都有分叉通知
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		forked := make(map[uint64]bool)
+		observer.mu.Lock()
+		for _, h := range observer.headers {
+			if h.Fork {
+				forked[h.Height] = true
+			}
+		}
+		observer.mu.Unlock()
+
+		if len(forked) == 5 && forked[6] && forked[10] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fork notifications = %v, want heights 6-10", forked)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	records, _ := bs.GetUnscanRecords()
+	for _, r := range records {
+		if r.BlockHeight == 7 {
+			t.Errorf("unscan record of orphaned block 7 not deleted")
+		}
+	}
+}
+
+func TestXBTBlockScanner_MaxReorgDepth_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(10)
+
+	wm := testMockWalletManager(t, srv, "maxReorgDepth = 3")
+	bs := wm.Blockscanner
+
+	var alerted uint64
+	bs.ReorgAlertFunc = func(headHeight, maxDepth uint64) {
+		alerted = headHeight
+	}
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	srv.Fork(5)
+	srv.AddBlocks(8)
+
+	bs.ScanBlockTask()
+
+	if alerted != 10 {
+		t.Errorf("reorg alert head height = %d, want 10", alerted)
+	}
+
+	//超过最大深度时不回退
+	height, _, _ := bs.GetLocalNewBlock()
+	if height != 10 {
+		t.Errorf("local height = %d, want 10", height)
+	}
+}
+
+func TestXBTBlockScanner_MemPool_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(3)
+
+	wm := testMockWalletManager(t, srv, "scanMemPool = true")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(3)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+
+	ts, _ := testSignedTx(t, testDepositAddress, "0.5")
+	if _, err := wm.ApiClient.sendTransaction(ts); err != nil {
+		t.Fatal(err)
+	}
+
+	//交易池中的充值只通知一次
+	bs.ScanBlockTask()
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 || confirmStatusOf(deposits[0]) != ConfirmStatusMemPool || deposits[0].Transaction.TxID != ts.Hash {
+		t.Fatalf("unexpected mempool deposits: %+v", deposits)
+	}
+
+	//打包后按区块提取，不再作为交易池交易通知
+	srv.MineSubmitted()
+	bs.ScanBlockTask()
+	bs.ScanBlockTask()
+
+	deposits = observer.extractData(testAccountID)
+	if len(deposits) != 2 || confirmStatusOf(deposits[1]) != "" || deposits[1].Transaction.BlockHeight != 4 {
+		t.Errorf("unexpected deposits after mined: %+v", deposits)
+	}
+}
+
+func TestXBTBlockScanner_MemPoolFallback_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(3)
+	srv.InjectFault(xbtmock.PathTxPending, xbtmock.Fault{HTTPStatus: http.StatusNotFound})
+
+	wm := testMockWalletManager(t, srv, "scanMemPool = true")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(3)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+
+	//节点不支持交易池查询时，使用本地广播的交易
+	wm.TxTracker.TrackTransaction(&Transaction{
+		TxID:   "localtx",
+		From:   testOtherAddress,
+		To:     testDepositAddress,
+		Amount: big.NewInt(2000000),
+		Fee:    big.NewInt(100000),
+	})
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 1 || confirmStatusOf(deposits[0]) != ConfirmStatusMemPool || deposits[0].TxOutputs[0].Amount != "2" {
+		t.Fatalf("unexpected mempool deposits: %+v", deposits)
+	}
+}
+
+func TestXBTBlockScanner_Reverify_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1"})
+	srv.AddBlocks(3)
+
+	//重扫数量超过区块高度
+	wm := testMockWalletManager(t, srv, "rescanLastBlockCount = 100")
+	bs := wm.Blockscanner
+	observer := newTestMockObserver()
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+	bs.ScanBlockTask()
+
+	//hash未变化的区块不重复通知
+	if n := len(observer.extractData(testAccountID)); n != 1 {
+		t.Fatalf("deposits = %d, want 1", n)
+	}
+
+	//第4个区块之后被替换，高度不变
+	srv.Fork(4)
+	replaced := srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "4"})
+	srv.AddBlocks(1)
+
+	bs.ScanBlockTask()
+
+	deposits := observer.extractData(testAccountID)
+	if len(deposits) != 2 || deposits[1].Transaction.TxID != replaced.Txs[0].Hash {
+		t.Fatalf("unexpected deposits: %+v", deposits)
+	}
+
+	local, _ := bs.GetLocalBlock(4)
+	if local.Hash != replaced.Hash {
+		t.Errorf("local block 4 hash = %s, want %s", local.Hash, replaced.Hash)
+	}
+}
@@ -80,6 +80,10 @@ type WalletConfig struct {
 	DataDir string
 	Decimal int32
 	NonceDiff uint64
+	//交易time的有效期，超过后拒绝广播，0为不检查
+	TxValidity time.Duration
+	//是否用xbt tools服务核对本地生成的地址
+	XbtToolsCheck bool
 }
 
 func NewConfig(symbol string, masterKey string) *WalletConfig {
@@ -101,6 +105,8 @@ func NewConfig(symbol string, masterKey string) *WalletConfig {
 	c.configFileName = c.Symbol + ".ini"
 	//本地数据库文件路径
 	c.dbPath = filepath.Join("data", strings.ToLower(c.Symbol), "db")
+	//交易有效期
+	c.TxValidity = defaultTxValidity
 	//备份路径
 	c.backupDir = filepath.Join("data", strings.ToLower(c.Symbol), "backup")
 	//钱包安装的路径
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/imroc/req"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultNodeMaxLag        = 5
+	defaultNodeCheckInterval = 30 * time.Second
+)
+
+//Endpoint 节点的健康状态
+type Endpoint struct {
+	URL       string
+	Height    uint64
+	Latency   time.Duration
+	Healthy   bool
+	LastCheck time.Time
+	LastError string
+}
+
+//EndpointPool 多个节点的健康检查及故障切换
+type EndpointPool struct {
+	MaxLag        uint64        //落后最高节点超过该区块数的节点不参与使用
+	CheckInterval time.Duration //健康检查间隔
+	Timeout       time.Duration //健康检查请求超时
+
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	current   string
+	lastCheck time.Time
+	client    *req.Req
+}
+
+//ParseEndpoints 解析逗号分隔的节点地址
+func ParseEndpoints(urls string) []string {
+	result := make([]string, 0)
+	for _, u := range strings.Split(urls, ",") {
+		u = strings.TrimSpace(u)
+		if len(u) > 0 {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+//NewEndpointPool 创建节点池，第一个节点为初始使用的节点
+func NewEndpointPool(urls []string) *EndpointPool {
+	pool := &EndpointPool{
+		MaxLag:        defaultNodeMaxLag,
+		CheckInterval: defaultNodeCheckInterval,
+		Timeout:       defaultRequestTimeout,
+		client:        req.New(),
+	}
+
+	//并发检查节点前创建http.Client
+	pool.client.Client()
+
+	for _, u := range urls {
+		pool.endpoints = append(pool.endpoints, &Endpoint{URL: u, Healthy: true})
+	}
+	if len(urls) > 0 {
+		pool.current = urls[0]
+	}
+
+	return pool
+}
+
+//Current 当前使用的节点
+func (pool *EndpointPool) Current() string {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.current
+}
+
+//Endpoints 所有节点的状态
+func (pool *EndpointPool) Endpoints() []Endpoint {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	result := make([]Endpoint, 0, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		result = append(result, *e)
+	}
+	return result
+}
+
+//Candidates 按优先级排列的可用节点地址：当前节点优先，其他节点区块高度高的优先，高度相同时按延迟排序。
+//不健康或落后过多的节点不参与使用，没有可用节点时重新检查一次，仍没有时返回错误
+func (pool *EndpointPool) Candidates() ([]string, error) {
+	pool.mu.RLock()
+	needCheck := time.Since(pool.lastCheck) > pool.CheckInterval
+	pool.mu.RUnlock()
+
+	if needCheck {
+		pool.Refresh()
+	}
+
+	result := pool.ranked()
+	if len(result) == 0 && !needCheck {
+		pool.Refresh()
+		result = pool.ranked()
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no healthy node api available")
+	}
+	return result, nil
+}
+
+//ranked 可用节点按优先级排序
+func (pool *EndpointPool) ranked() []string {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var bestHeight uint64
+	for _, e := range pool.endpoints {
+		if e.Healthy && e.Height > bestHeight {
+			bestHeight = e.Height
+		}
+	}
+
+	ranked := make([]*Endpoint, 0, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		if e.Healthy && e.Height+pool.MaxLag >= bestHeight {
+			ranked = append(ranked, e)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		//当前节点没有落后过多时继续使用，避免每个新区块都切换节点
+		if (a.URL == pool.current) != (b.URL == pool.current) {
+			return a.URL == pool.current
+		}
+		if a.Height != b.Height {
+			return a.Height > b.Height
+		}
+		return a.Latency < b.Latency
+	})
+
+	result := make([]string, 0, len(ranked))
+	for _, e := range ranked {
+		result = append(result, e.URL)
+	}
+	return result
+}
+
+//Refresh 检查所有节点的区块高度和延迟，落后过多或不可用的节点标记为不健康
+func (pool *EndpointPool) Refresh() {
+	pool.mu.RLock()
+	urls := make([]string, 0, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		urls = append(urls, e.URL)
+	}
+	pool.mu.RUnlock()
+
+	type checkResult struct {
+		height  uint64
+		latency time.Duration
+		err     error
+	}
+
+	results := make([]checkResult, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			start := time.Now()
+			height, err := pool.getBlockHeight(u)
+			results[i] = checkResult{height: height, latency: time.Since(start), err: err}
+		}(i, u)
+	}
+	wg.Wait()
+
+	var bestHeight uint64
+	for _, r := range results {
+		if r.err == nil && r.height > bestHeight {
+			bestHeight = r.height
+		}
+	}
+
+	pool.mu.Lock()
+	now := time.Now()
+	for i, e := range pool.endpoints {
+		r := results[i]
+		e.LastCheck = now
+		e.Latency = r.latency
+		if r.err != nil {
+			e.Healthy = false
+			e.LastError = r.err.Error()
+			continue
+		}
+		e.Height = r.height
+		e.Healthy = r.height+pool.MaxLag >= bestHeight
+		if e.Healthy {
+			e.LastError = ""
+		} else {
+			e.LastError = "block height " + strconv.FormatUint(r.height, 10) + " lags behind " + strconv.FormatUint(bestHeight, 10)
+		}
+	}
+	pool.lastCheck = now
+	pool.mu.Unlock()
+
+	//当前节点不健康时切换到最优节点
+	for _, e := range pool.Endpoints() {
+		if e.URL == pool.Current() && !e.Healthy {
+			candidates := pool.ranked()
+			if len(candidates) > 0 {
+				pool.SwitchTo(candidates[0], e.LastError)
+			}
+			break
+		}
+	}
+}
+
+//MarkFailed 请求失败时标记节点不健康，等待下次健康检查恢复
+func (pool *EndpointPool) MarkFailed(url string, err error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, e := range pool.endpoints {
+		if e.URL == url {
+			e.Healthy = false
+			e.LastError = err.Error()
+		}
+	}
+}
+
+//SwitchTo 切换当前使用的节点
+func (pool *EndpointPool) SwitchTo(url, reason string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.current == url {
+		return
+	}
+
+	log.Std.Warning("node api switched from %s to %s, reason: %s", pool.current, url, reason)
+	pool.current = url
+}
+
+func (pool *EndpointPool) getBlockHeight(url string) (uint64, error) {
+	policy := &TransportPolicy{Timeout: pool.Timeout, MaxAttempts: 1}
+	body := map[string]interface{}{}
+
+	r, err := policy.post(pool.client, url+"/open/block/height", req.BodyJSON(&body))
+	if err != nil {
+		return 0, err
+	}
+
+	resp := gjson.ParseBytes(r)
+	if resp.Get("code").Int() != 200 {
+		return 0, errors.New("wrong code : " + resp.Get("code").String())
+	}
+
+	return strconv.ParseUint(resp.Get("data").Raw, 10, 64)
+}
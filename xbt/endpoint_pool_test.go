@@ -0,0 +1,170 @@
+package xbt
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+func testPoolClient(urls ...string) *Client {
+	c := NewClient(urls[0], false, symbol, currencyDecimal)
+	c.Policy = &TransportPolicy{Timeout: defaultRequestTimeout, MaxAttempts: 1}
+	c.Pool = NewEndpointPool(urls)
+	return c
+}
+
+func TestParseEndpoints(t *testing.T) {
+	urls := ParseEndpoints(" http://a:1, ,http://b:2 ")
+	if len(urls) != 2 || urls[0] != "http://a:1" || urls[1] != "http://b:2" {
+		t.Errorf("unexpected endpoints: %v", urls)
+	}
+}
+
+func TestEndpointPool_Refresh_ExcludeLagging(t *testing.T) {
+	lagging := xbtmock.NewServer()
+	defer lagging.Close()
+	best := xbtmock.NewServer()
+	defer best.Close()
+
+	lagging.AddBlocks(3)
+	best.AddBlocks(20)
+
+	pool := NewEndpointPool([]string{lagging.URL, best.URL})
+	pool.MaxLag = 5
+	pool.Refresh()
+
+	if pool.Current() != best.URL {
+		t.Errorf("current = %s, want %s", pool.Current(), best.URL)
+	}
+	for _, e := range pool.Endpoints() {
+		if e.URL == lagging.URL && e.Healthy {
+			t.Errorf("lagging node should be unhealthy")
+		}
+		if e.URL == best.URL && (!e.Healthy || e.Height != 20) {
+			t.Errorf("unexpected best node status: %+v", e)
+		}
+	}
+}
+
+func TestEndpointPool_Candidates_KeepCurrent(t *testing.T) {
+	current := xbtmock.NewServer()
+	defer current.Close()
+	ahead := xbtmock.NewServer()
+	defer ahead.Close()
+
+	current.AddBlocks(10)
+	ahead.AddBlocks(11)
+
+	//当前节点只落后一个区块时不切换
+	c := testPoolClient(current.URL, ahead.URL)
+	candidates, err := c.Pool.Candidates()
+	if err != nil {
+		t.Fatalf("Candidates failed: %v", err)
+	}
+	if len(candidates) != 2 || candidates[0] != current.URL || candidates[1] != ahead.URL {
+		t.Errorf("unexpected candidates: %v", candidates)
+	}
+	if _, err := c.getBlockHeight(); err != nil {
+		t.Fatalf("getBlockHeight failed: %v", err)
+	}
+	if c.Pool.Current() != current.URL || ahead.Requests(xbtmock.PathBlockHeight) != 1 {
+		t.Errorf("current = %s, ahead requests = %d", c.Pool.Current(), ahead.Requests(xbtmock.PathBlockHeight))
+	}
+}
+
+func TestClient_Failover_Mock(t *testing.T) {
+	primary := xbtmock.NewServer()
+	defer primary.Close()
+	backup := xbtmock.NewServer()
+	defer backup.Close()
+
+	primary.AddBlocks(5)
+	backup.AddBlocks(5)
+
+	c := testPoolClient(primary.URL, backup.URL)
+
+	primary.InjectFault(xbtmock.PathBlockRange, xbtmock.Fault{HTTPStatus: http.StatusServiceUnavailable})
+
+	block, err := c.getBlockByHeight(3)
+	if err != nil {
+		t.Fatalf("getBlockByHeight failed: %v", err)
+	}
+	if block.Hash != backup.GetBlock(3).Hash {
+		t.Errorf("block not fetched from backup node")
+	}
+	if c.Pool.Current() != backup.URL {
+		t.Errorf("current = %s, want %s", c.Pool.Current(), backup.URL)
+	}
+
+	//备用节点停止后切换回主节点
+	primary.ClearFaults()
+	backup.Close()
+
+	if _, err := c.getBlockHeight(); err != nil {
+		t.Fatalf("getBlockHeight failed: %v", err)
+	}
+	if c.Pool.Current() != primary.URL {
+		t.Errorf("current = %s, want %s", c.Pool.Current(), primary.URL)
+	}
+}
+
+func TestClient_SendNoFailover_Mock(t *testing.T) {
+	primary := xbtmock.NewServer()
+	defer primary.Close()
+	backup := xbtmock.NewServer()
+	defer backup.Close()
+
+	c := testPoolClient(primary.URL, backup.URL)
+
+	//广播失败时不切换到备用节点重发
+	ts, _ := testSignedTx(t, testDepositAddress, "0.5")
+	primary.InjectFault(xbtmock.PathTxSend, xbtmock.Fault{HTTPStatus: http.StatusServiceUnavailable})
+	if _, err := c.sendTransaction(ts); err == nil {
+		t.Fatal("expected error when primary node rejects broadcast")
+	}
+	if n := backup.Requests(xbtmock.PathTxSend); n != 0 {
+		t.Errorf("backup send requests = %d, want 0", n)
+	}
+}
+
+func TestEndpointPool_Candidates(t *testing.T) {
+	lagging := xbtmock.NewServer()
+	defer lagging.Close()
+	lower := xbtmock.NewServer()
+	defer lower.Close()
+	best := xbtmock.NewServer()
+	defer best.Close()
+
+	lagging.AddBlocks(3)
+	lower.AddBlocks(18)
+	best.AddBlocks(20)
+
+	//按区块高度排序，落后过多的节点不参与使用
+	pool := NewEndpointPool([]string{lagging.URL, lower.URL, best.URL})
+	pool.MaxLag = 5
+	candidates, err := pool.Candidates()
+	if err != nil {
+		t.Fatalf("Candidates failed: %v", err)
+	}
+	if len(candidates) != 2 || candidates[0] != best.URL || candidates[1] != lower.URL {
+		t.Errorf("unexpected candidates: %v", candidates)
+	}
+
+	//请求失败的节点不参与使用
+	pool.MarkFailed(best.URL, errors.New("timeout"))
+	candidates, _ = pool.Candidates()
+	if len(candidates) != 1 || candidates[0] != lower.URL {
+		t.Errorf("unexpected candidates after failure: %v", candidates)
+	}
+
+	//所有节点都不可用时返回错误
+	lagging.Close()
+	lower.Close()
+	best.Close()
+	pool.MarkFailed(lower.URL, errors.New("timeout"))
+	if candidates, err := pool.Candidates(); err == nil {
+		t.Errorf("expected error, got candidates: %v", candidates)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/astaxie/beego/config"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FeeModePercentage = "percentage" //按金额比例收取，限制在最低及最高手续费之间
+	FeeModeFlat       = "flat"       //固定手续费
+	FeeModeTiered     = "tiered"     //按金额分档
+	FeeModeNode       = "node"       //由节点接口计算，节点不可用时按fallback的方式计算
+
+	defaultFeeRatio = "0.002"
+)
+
+//FeeTier 分档手续费，金额不超过UpTo时使用该档，UpTo为nil表示不限上限
+type FeeTier struct {
+	UpTo    *decimal.Decimal
+	Fee     decimal.Decimal
+	IsRatio bool //Fee为金额的比例
+}
+
+//FeeSchedule 手续费规则
+type FeeSchedule struct {
+	Mode     string
+	Ratio    decimal.Decimal //percentage方式的费率
+	Flat     decimal.Decimal //flat方式的手续费
+	Tiers    []*FeeTier      //tiered方式的分档，按UpTo升序
+	Min      decimal.Decimal //最低手续费，flat方式不限制
+	Max      decimal.Decimal //最高手续费，0为不限制，flat方式不限制
+	Endpoint string          //node方式的节点接口路径
+	Fallback *FeeSchedule    //node方式下节点不可用时使用的规则
+}
+
+//NewFeeSchedule 默认规则：金额的千分之2
+func NewFeeSchedule() *FeeSchedule {
+	ratio, _ := decimal.NewFromString(defaultFeeRatio)
+	return &FeeSchedule{Mode: FeeModePercentage, Ratio: ratio}
+}
+
+//Fee 按规则计算手续费，node方式由调用方请求节点
+func (s *FeeSchedule) Fee(amount decimal.Decimal, decimals int32) (decimal.Decimal, error) {
+	switch s.Mode {
+	case FeeModeFlat:
+		return s.Flat, nil
+	case FeeModePercentage:
+		return s.Clamp(amount.Mul(s.Ratio).Round(decimals)), nil
+	case FeeModeTiered:
+		for _, tier := range s.Tiers {
+			if tier.UpTo != nil && amount.GreaterThan(*tier.UpTo) {
+				continue
+			}
+			fee := tier.Fee
+			if tier.IsRatio {
+				fee = amount.Mul(tier.Fee).Round(decimals)
+			}
+			return s.Clamp(fee), nil
+		}
+		return decimal.Zero, fmt.Errorf("no fee tier for amount %s", amount.String())
+	case FeeModeNode:
+		if s.Fallback == nil {
+			return decimal.Zero, fmt.Errorf("fee schedule %s has no fallback", s.Mode)
+		}
+		return s.Fallback.Fee(amount, decimals)
+	}
+	return decimal.Zero, fmt.Errorf("unknown fee mode: %s", s.Mode)
+}
+
+//Clamp 将手续费限制在最低及最高手续费之间
+func (s *FeeSchedule) Clamp(fee decimal.Decimal) decimal.Decimal {
+	if fee.LessThan(s.Min) {
+		fee = s.Min
+	}
+	if s.Max.IsPositive() && fee.GreaterThan(s.Max) {
+		fee = s.Max
+	}
+	return fee
+}
+
+//String 规则的描述，如：percentage 0.2% min 0.1 max 10
+func (s *FeeSchedule) String() string {
+	var desc string
+	switch s.Mode {
+	case FeeModeFlat:
+		return FeeModeFlat + " " + s.Flat.String()
+	case FeeModePercentage:
+		desc = FeeModePercentage + " " + ratioString(s.Ratio)
+	case FeeModeTiered:
+		tiers := make([]string, 0, len(s.Tiers))
+		for _, tier := range s.Tiers {
+			upTo := "*"
+			if tier.UpTo != nil {
+				upTo = tier.UpTo.String()
+			}
+			fee := tier.Fee.String()
+			if tier.IsRatio {
+				fee = ratioString(tier.Fee)
+			}
+			tiers = append(tiers, upTo+":"+fee)
+		}
+		desc = FeeModeTiered + " " + strings.Join(tiers, ",")
+	case FeeModeNode:
+		desc = FeeModeNode + " " + s.Endpoint
+		if s.Fallback != nil {
+			desc = desc + " fallback " + s.Fallback.String()
+		}
+		return desc
+	default:
+		return s.Mode
+	}
+
+	if s.Min.IsPositive() {
+		desc = desc + " min " + s.Min.String()
+	}
+	if s.Max.IsPositive() {
+		desc = desc + " max " + s.Max.String()
+	}
+	return desc
+}
+
+func ratioString(ratio decimal.Decimal) string {
+	return ratio.Mul(decimal.New(100, 0)).String() + "%"
+}
+
+//ParseFeeTiers 解析分档配置，格式：100:0.1,1000:1,*:0.1%，金额按升序排列，*为不限上限，%结尾的手续费为金额的比例
+func ParseFeeTiers(str string) ([]*FeeTier, error) {
+	tiers := make([]*FeeTier, 0)
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("wrong fee tier: %s", item)
+		}
+
+		tier := &FeeTier{}
+		upTo := strings.TrimSpace(parts[0])
+		if upTo != "*" {
+			d, err := decimal.NewFromString(upTo)
+			if err != nil {
+				return nil, fmt.Errorf("wrong fee tier amount: %s", item)
+			}
+			if len(tiers) > 0 {
+				last := tiers[len(tiers)-1]
+				if last.UpTo == nil || !d.GreaterThan(*last.UpTo) {
+					return nil, fmt.Errorf("fee tiers must be in ascending order: %s", item)
+				}
+			}
+			tier.UpTo = &d
+		} else if len(tiers) > 0 && tiers[len(tiers)-1].UpTo == nil {
+			return nil, fmt.Errorf("duplicate unbounded fee tier: %s", item)
+		}
+
+		fee := strings.TrimSpace(parts[1])
+		if strings.HasSuffix(fee, "%") {
+			fee = strings.TrimSuffix(fee, "%")
+			tier.IsRatio = true
+		}
+		d, err := decimal.NewFromString(fee)
+		if err != nil || d.IsNegative() {
+			return nil, fmt.Errorf("wrong fee tier fee: %s", item)
+		}
+		if tier.IsRatio {
+			d = d.Div(decimal.New(100, 0))
+		}
+		tier.Fee = d
+
+		tiers = append(tiers, tier)
+	}
+
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("fee tiers is empty")
+	}
+	return tiers, nil
+}
+
+//loadFeeSchedule 读取手续费规则，未配置feeMin时以fixedFee作为最低手续费
+func loadFeeSchedule(c config.Configer) (*FeeSchedule, error) {
+	mode := c.String("feeMode")
+	if len(mode) == 0 {
+		mode = FeeModePercentage
+	}
+
+	schedule, err := loadFeeScheduleOfMode(c, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == FeeModeNode {
+		schedule.Endpoint = c.String("feeEndpoint")
+		if len(schedule.Endpoint) == 0 {
+			return nil, fmt.Errorf("feeEndpoint is required when feeMode = %s", FeeModeNode)
+		}
+
+		fallbackMode := c.String("feeFallbackMode")
+		if len(fallbackMode) == 0 {
+			fallbackMode = FeeModePercentage
+		}
+		if fallbackMode == FeeModeNode {
+			return nil, fmt.Errorf("wrong feeFallbackMode : %s", fallbackMode)
+		}
+		schedule.Fallback, err = loadFeeScheduleOfMode(c, fallbackMode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return schedule, nil
+}
+
+func loadFeeScheduleOfMode(c config.Configer, mode string) (*FeeSchedule, error) {
+	schedule := NewFeeSchedule()
+	schedule.Mode = mode
+
+	values := map[string]*decimal.Decimal{
+		"feeRatio": &schedule.Ratio,
+		"feeFlat":  &schedule.Flat,
+		"feeMin":   &schedule.Min,
+		"feeMax":   &schedule.Max,
+	}
+	if len(c.String("feeMin")) == 0 {
+		values["fixedFee"] = &schedule.Min
+	}
+	for key, value := range values {
+		str := c.String(key)
+		if len(str) == 0 {
+			continue
+		}
+		d, err := decimal.NewFromString(str)
+		if err != nil || d.IsNegative() {
+			return nil, fmt.Errorf("wrong %s : %s", key, str)
+		}
+		*value = d
+	}
+
+	if schedule.Max.IsPositive() && schedule.Max.LessThan(schedule.Min) {
+		return nil, fmt.Errorf("feeMax %s is less than feeMin %s", schedule.Max.String(), schedule.Min.String())
+	}
+
+	switch mode {
+	case FeeModePercentage, FeeModeNode:
+	case FeeModeFlat:
+		if len(c.String("feeFlat")) == 0 {
+			return nil, fmt.Errorf("feeFlat is required when feeMode = %s", FeeModeFlat)
+		}
+	case FeeModeTiered:
+		tiers, err := ParseFeeTiers(c.String("feeTiers"))
+		if err != nil {
+			return nil, err
+		}
+		schedule.Tiers = tiers
+	default:
+		return nil, fmt.Errorf("unknown fee mode: %s", mode)
+	}
+
+	return schedule, nil
+}
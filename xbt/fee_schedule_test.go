@@ -0,0 +1,122 @@
+package xbt
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+	"github.com/shopspring/decimal"
+)
+
+func testFeeSchedule(t *testing.T, ini string) *FeeSchedule {
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := loadFeeSchedule(c)
+	if err != nil {
+		t.Fatalf("loadFeeSchedule failed: %v", err)
+	}
+	return schedule
+}
+
+func TestFeeSchedule_Fee(t *testing.T) {
+	cases := []struct {
+		ini    string
+		amount string
+		want   string
+		desc   string
+	}{
+		//未配置时与原来的规则一致：千分之2，最低fixedFee
+		{`fixedFee = "0.1"`, "10", "0.1", "percentage 0.2% min 0.1"},
+		{`fixedFee = "0.1"`, "1000", "2", "percentage 0.2% min 0.1"},
+		{"feeRatio = \"0.01\"\nfeeMin = \"0.5\"\nfeeMax = \"3\"", "1000", "3", "percentage 1% min 0.5 max 3"},
+		{"feeMode = \"flat\"\nfeeFlat = \"0.25\"\nfixedFee = \"1\"", "1000", "0.25", "flat 0.25"},
+		{"feeMode = \"tiered\"\nfeeTiers = \"100:0.1,1000:1,*:0.1%\"", "100", "0.1", "tiered 100:0.1,1000:1,*:0.1%"},
+		{"feeMode = \"tiered\"\nfeeTiers = \"100:0.1,1000:1,*:0.1%\"", "100.5", "1", "tiered 100:0.1,1000:1,*:0.1%"},
+		{"feeMode = \"tiered\"\nfeeTiers = \"100:0.1,1000:1,*:0.1%\"\nfeeMax = \"5\"", "20000", "5", "tiered 100:0.1,1000:1,*:0.1% max 5"},
+	}
+
+	for _, c := range cases {
+		schedule := testFeeSchedule(t, c.ini)
+		amount, _ := decimal.NewFromString(c.amount)
+		fee, err := schedule.Fee(amount, currencyDecimal)
+		if err != nil {
+			t.Fatalf("%s: Fee failed: %v", c.ini, err)
+		}
+		if fee.String() != c.want {
+			t.Errorf("%s: fee of %s = %s, want %s", c.ini, c.amount, fee.String(), c.want)
+		}
+		if schedule.String() != c.desc {
+			t.Errorf("%s: desc = %s, want %s", c.ini, schedule.String(), c.desc)
+		}
+	}
+}
+
+func TestFeeSchedule_WrongConfig(t *testing.T) {
+	inis := []string{
+		`feeMode = "unknown"`,
+		`feeMode = "flat"`,
+		`feeMode = "node"`,
+		"feeMode = \"tiered\"\nfeeTiers = \"1000:1,100:0.1\"",
+		"feeMode = \"tiered\"\nfeeTiers = \"*:1,*:2\"",
+		"feeMode = \"tiered\"\nfeeTiers = \"100\"",
+		"feeMin = \"2\"\nfeeMax = \"1\"",
+		`feeRatio = "-0.1"`,
+	}
+	for _, ini := range inis {
+		c, _ := config.NewConfigData("ini", []byte(ini))
+		if _, err := loadFeeSchedule(c); err == nil {
+			t.Errorf("%s: expected error", ini)
+		}
+	}
+
+	//最后一档有上限时，超出的金额没有对应的档位
+	schedule := testFeeSchedule(t, "feeMode = \"tiered\"\nfeeTiers = \"100:0.1\"")
+	if _, err := schedule.Fee(decimal.New(101, 0), currencyDecimal); err == nil {
+		t.Error("expected error for amount above the last tier")
+	}
+}
+
+func TestTransactionDecoder_GetTxFee_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	wm := testMockWalletManager(t, srv,
+		`feeMode = "node"`,
+		`feeEndpoint = "`+xbtmock.PathTxFee+`"`,
+		`feeMax = "1"`,
+	)
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+	amount := decimal.New(1000, 0)
+
+	feeRate, _, _ := decoder.GetRawTransactionFeeRate()
+	if feeRate != "node /open/tx/fee fallback percentage 0.2% min 0.1 max 1" {
+		t.Errorf("unexpected fee rate: %s", feeRate)
+	}
+
+	srv.SetTxFee("0.3")
+	if fee, err := decoder.GetTxFee("", &amount); err != nil || fee.String() != "0.3" {
+		t.Errorf("node fee = %s, %v", fee.String(), err)
+	}
+
+	//节点返回的手续费同样限制在最低及最高手续费之间
+	srv.SetTxFee("0.01")
+	if fee, _ := decoder.GetTxFee("", &amount); fee.String() != "0.1" {
+		t.Errorf("clamped node fee = %s, want 0.1", fee.String())
+	}
+
+	//节点不可用时按fallback计算
+	srv.SetTxFee("")
+	if fee, err := decoder.GetTxFee("", &amount); err != nil || fee.String() != "1" {
+		t.Errorf("fallback fee = %s, %v", fee.String(), err)
+	}
+
+	//指定的feeRate不能超出限制
+	if fee, _ := decoder.GetTxFee("5", &amount); fee.String() != "1" {
+		t.Errorf("custom fee = %s, want 1", fee.String())
+	}
+	if _, err := decoder.GetTxFee("abc", &amount); err == nil {
+		t.Error("expected error for wrong feeRate")
+	}
+}
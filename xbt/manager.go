@@ -21,6 +21,7 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"path/filepath"
+	"sync"
 )
 
 type WalletManager struct {
@@ -37,6 +38,12 @@ type WalletManager struct {
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	Log             *log.OWLogger                 //日志工具
 	ContractDecoder *ContractDecoder              //智能合约解析器
+	SenderSelector  *SenderSelector               //发送地址选择器
+	TxTracker       *TxTracker                    //已广播交易的确认跟踪
+	NonceManager    *NonceManager                 //发送地址的nonce管理
+	FeeSchedule     *FeeSchedule                  //手续费规则
+
+	rebuildMu sync.Mutex //过期交易重建记录的读写锁
 }
 
 func NewWalletManager() *WalletManager {
@@ -52,6 +59,10 @@ func NewWalletManager() *WalletManager {
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.SenderSelector, _ = NewSenderSelector(SenderStrategyLargestFirst)
+	wm.TxTracker = NewTxTracker(defaultTxDropTimeout)
+	wm.NonceManager, _ = NewNonceManager(NonceStrategySequential, wm.Symbol())
+	wm.FeeSchedule = NewFeeSchedule()
 
 	//	wm.RPCClient = NewRpcClient("http://localhost:20336/")
 	return &wm
@@ -92,70 +103,3 @@ func (wm *WalletManager) GetWallets() ([]*openwallet.Wallet, error) {
 
 }
 
-//// GetAddressNonce
-//func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, account *AddrBalance) uint64 {
-//	var (
-//		key           = wm.Symbol() + "-nonce"
-//		nonce         uint64
-//		nonce_db      interface{}
-//		nonce_onchain uint64
-//	)
-//
-//	//获取db记录的nonce并确认nonce值
-//	nonce_db, _ = wrapper.GetAddressExtParam(account.Address, key)
-//
-//	//判断nonce_db是否为空,为空则说明当前nonce是0
-//	if nonce_db == nil {
-//		nonce = 0
-//	} else {
-//		//nonce = common.NewString(nonce_db).UInt64()
-//		nonceStr := common.NewString(nonce_db)
-//		nonceStrArr := strings.Split(string(nonceStr), "_")
-//
-//		if len(nonceStrArr)>1 {
-//			saveTime := common.NewString(nonceStrArr[1]).UInt64()
-//			now := uint64(time.Now().Unix())
-//			diff, _ := math.SafeSub(now, saveTime)
-//
-//			if diff > wm.Config.NonceDiff { //当前时间减去保存时间，超过1小时，就不算了
-//				nonce = 0
-//			} else {
-//				nonce = common.NewString(nonceStrArr[0]).UInt64()
-//			}
-//		}else{
-//			nonce = common.NewString(nonce_db).UInt64()
-//		}
-//	}
-//
-//	nonce_onchain = account.Nonce
-//
-//	wm.Log.Info(account.Address, " get nonce : ", nonce, ", nonce_onchain : ", nonce_onchain)
-//
-//	//如果本地nonce_db > 链上nonce,采用本地nonce,否则采用链上nonce
-//	if nonce > nonce_onchain {
-//		//wm.Log.Debugf("%s nonce_db=%v > nonce_chain=%v,Use nonce_db...", address, nonce_db, nonce_onchain)
-//	} else {
-//		nonce = nonce_onchain
-//		//wm.Log.Debugf("%s nonce_db=%v <= nonce_chain=%v,Use nonce_chain...", address, nonce_db, nonce_onchain)
-//	}
-//
-//	////临时
-//	//if nonce > 430 && nonce < 450 {
-//	//	nonce = nonce_onchain
-//	//}
-//
-//	return nonce
-//}
-//
-//// UpdateAddressNonce
-//func (wm *WalletManager) UpdateAddressNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
-//	key := wm.Symbol() + "-nonce"
-//
-//	nonceStr := strconv.FormatUint(nonce, 10) + "_" + strconv.FormatUint( uint64(time.Now().Unix()), 10)
-//	wm.Log.Info(address, " set nonce ", nonceStr)
-//
-//	err := wrapper.SetAddressExtParam(address, key, nonceStr)
-//	if err != nil {
-//		wm.Log.Errorf("WalletDAI SetAddressExtParam failed, err: %v", err)
-//	}
-//}
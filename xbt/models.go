@@ -17,11 +17,9 @@ package xbt
 import (
 	"fmt"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"math/big"
-	"strconv"
 	"time"
 )
 
@@ -35,16 +33,17 @@ type Block struct {
 
 type Transaction struct {
 	TxID        string
-	Fee         uint64
+	Fee         *big.Int //最小单位
 	TimeStamp   uint64
 	From        string
 	To          string
-	Amount      uint64
+	Amount      *big.Int //最小单位
 	BlockHeight uint64
 	BlockHash   string
 	Status      string
 	ToArr       []string //@required 格式："地址":"数量"
 	ToDecArr    []string //@required 格式："地址":"数量(带小数)"
+	ParseError  string   //解析失败的原因，不为空时该交易不能提取
 }
 
 func GetTransactionInBlock(json *gjson.Result, decimal int32) []Transaction {
@@ -52,28 +51,16 @@ func GetTransactionInBlock(json *gjson.Result, decimal int32) []Transaction {
 	blockHeight := gjson.Get(json.Raw, "height").Uint()
 	transactions := make([]Transaction, 0)
 
-	blockTime := gjson.Get(json.Raw, "time").Uint()
+	blockTime := parseBlockTime(gjson.Get(json.Raw, "time"))
 
 	for _, txItem := range gjson.Get(json.Raw, "tx").Array() {
-		txid := gjson.Get(txItem.Raw, "hash").String()
-		from := gjson.Get(txItem.Raw, "send_address").String()          //来源地址
-		to := gjson.Get(txItem.Raw, "receive_address").String()            //目标地址
-		amountStr := gjson.Get(txItem.Raw, "amount").String()      //金额
-		feeStr := gjson.Get(txItem.Raw, "fee").String() 			//手续费
-
-		amount := convertFromAmount(amountStr, decimal)
-		fee := convertFromAmount(feeStr, decimal)
-		transaction := Transaction{
-			TxID:        txid,
-			Fee:         fee,
-			TimeStamp:   blockTime,
-			From:        from,
-			To:          to,
-			Amount:      amount,
-			BlockHeight: blockHeight,
-			BlockHash:   blockHash,
-			Status:      "1",
+		transaction := NewTransaction(&txItem, decimal)
+		//区块没有时间时保留交易自身的时间
+		if blockTime > 0 {
+			transaction.TimeStamp = blockTime
 		}
+		transaction.BlockHeight = blockHeight
+		transaction.BlockHash = blockHash
 
 		transactions = append(transactions, transaction)
 
@@ -82,12 +69,47 @@ func GetTransactionInBlock(json *gjson.Result, decimal int32) []Transaction {
 	return transactions
 }
 
+//NewTransaction 解析节点返回的交易，金额解析失败时记录在ParseError
+func NewTransaction(txItem *gjson.Result, decimal int32) Transaction {
+	txid := gjson.Get(txItem.Raw, "hash").String()
+	from := gjson.Get(txItem.Raw, "send_address").String()          //来源地址
+	to := gjson.Get(txItem.Raw, "receive_address").String()            //目标地址
+	amountStr := amountInJson(gjson.Get(txItem.Raw, "amount"))      //金额
+	feeStr := amountInJson(gjson.Get(txItem.Raw, "fee")) 			//手续费
+
+	parseError := ""
+	amount, err := convertFromAmount(amountStr, decimal)
+	if err != nil {
+		parseError = "wrong amount : " + err.Error()
+	}
+	//节点没有返回手续费时视为0
+	fee := big.NewInt(0)
+	if len(feeStr) > 0 {
+		fee, err = convertFromAmount(feeStr, decimal)
+		if err != nil {
+			parseError = "wrong fee : " + err.Error()
+		}
+	}
+
+	return Transaction{
+		TxID:       txid,
+		Fee:        fee,
+		TimeStamp:  parseBlockTime(gjson.Get(txItem.Raw, "time")),
+		From:       from,
+		To:         to,
+		Amount:     amount,
+		Status:     "1",
+		ParseError: parseError,
+	}
+}
+
 func NewBlock(json *gjson.Result, decimal int32) *Block {
 	obj := &Block{}
 	// 解析
 	obj.Hash = gjson.Get(json.Raw, "hash").String()
 	obj.PrevBlockHash = gjson.Get(json.Raw, "prev_hash").String()
 	obj.Height = gjson.Get(json.Raw, "height").Uint()
+	obj.Timestamp = parseBlockTime(gjson.Get(json.Raw, "time"))
 	obj.Transactions = GetTransactionInBlock(json, decimal)
 
 	if obj.Hash == "" {
@@ -105,11 +127,32 @@ func (b *Block) BlockHeader() *openwallet.BlockHeader {
 	//obj.Confirmations = b.Confirmations
 	obj.Previousblockhash = b.PrevBlockHash
 	obj.Height = b.Height
+	obj.Time = b.Timestamp
 	//obj.Symbol = Symbol
 
 	return &obj
 }
 
+//parseBlockTime 解析节点返回的区块时间，统一为秒级时间戳，支持秒、毫秒及RFC3339格式
+func parseBlockTime(value gjson.Result) uint64 {
+	if value.Type == gjson.String {
+		if t, err := time.Parse(time.RFC3339, value.String()); err == nil {
+			return uint64(t.Unix())
+		}
+	}
+
+	return normalizeTimestamp(value.Uint())
+}
+
+//normalizeTimestamp 毫秒、微秒级的时间戳转为秒
+func normalizeTimestamp(ts uint64) uint64 {
+	//秒级时间戳在公元5138年前不会超过1e11
+	for ts >= 1e11 {
+		ts = ts / 1000
+	}
+	return ts
+}
+
 type AddrBalance struct {
 	Address string
 	Balance *big.Int
@@ -120,23 +163,46 @@ type AddrBalance struct {
 	Actived bool
 }
 
+// 读取json中的金额，数字类型使用原始文本，避免转为float64丢失精度
+func amountInJson(value gjson.Result) string {
+	if value.Type == gjson.Number {
+		return value.Raw
+	}
+	return value.String()
+}
+
 // 从最小单位的 amount 转为带小数点的表示
-func convertToAmount(amount uint64, amountDecimal int32) string {
-	amountStr := fmt.Sprintf("%d", amount)
-	d, _ := decimal.NewFromString(amountStr)
-	ten := math.BigPow(10, int64(amountDecimal) )
-	w, _ := decimal.NewFromString(ten.String())
-
-	d = d.Div(w)
-	return d.String()
+func convertToAmount(amount *big.Int, amountDecimal int32) string {
+	if amount == nil {
+		return "0"
+	}
+	return decimal.NewFromBigInt(amount, -amountDecimal).String()
+}
+
+// amount 字符串转为最小单位的表示，不能为负数，小数位不能超过精度
+func convertFromAmount(amountStr string, amountDecimal int32) (*big.Int, error) {
+	d, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q: %v", amountStr, err)
+	}
+	if d.IsNegative() {
+		return nil, fmt.Errorf("negative amount %q", amountStr)
+	}
+
+	d = d.Shift(amountDecimal)
+	if !d.Equal(d.Truncate(0)) {
+		return nil, fmt.Errorf("amount %q exceeds %d decimals", amountStr, amountDecimal)
+	}
+
+	r, ok := new(big.Int).SetString(d.Truncate(0).String(), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amountStr)
+	}
+	return r, nil
 }
 
-// amount 字符串转为最小单位的表示
-func convertFromAmount(amountStr string, amountDecimal int32) uint64 {
-	d, _ := decimal.NewFromString(amountStr)
-	ten := math.BigPow(10, int64(amountDecimal) )
-	w, _ := decimal.NewFromString(ten.String())
-	d = d.Mul(w)
-	r, _ := strconv.ParseInt(d.String(), 10, 64)
-	return uint64(r)
+// 带小数的数量转为最小单位的big.Int，超出精度的部分截断
+func convertDecimalToBigInt(amount decimal.Decimal, amountDecimal int32) *big.Int {
+	r, _ := new(big.Int).SetString(amount.Shift(amountDecimal).Truncate(0).String(), 10)
+	return r
 }
@@ -18,13 +18,16 @@ package xbt
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/xbt-adapter/xbtTransaction"
 	"github.com/imroc/req"
+	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"math/big"
+	"net/http"
+	"sort"
 	"strconv"
-	"time"
 )
 
 type ClientInterface interface {
@@ -41,6 +44,8 @@ type Client struct {
 	client      *req.Req
 	Symbol      string
 	Decimal     int32
+	Policy      *TransportPolicy
+	Pool        *EndpointPool //多节点时的节点池，为nil时只使用BaseURL
 }
 
 type Response struct {
@@ -61,10 +66,13 @@ func NewClient(url string /*token string,*/, debug bool, symbol string, decimal
 	log.Debug("BaseURL : ", url)
 
 	api := req.New()
+	//req首次使用时才创建http.Client，不是并发安全的，提前创建
+	api.Client()
 
 	c.client = api
 	c.Symbol = symbol
 	c.Decimal = decimal
+	c.Policy = NewTransportPolicy()
 
 	return &c
 }
@@ -75,23 +83,21 @@ func (c *Client) PostCall(path string, v map[string]interface{}) (*gjson.Result,
 		log.Debug("Start Request API, url : ", path, ", body : ", v)
 	}
 
-	r, err := req.Post(c.BaseURL+path, req.BodyJSON(&v))
+	r, err := c.post(path, req.BodyJSON(&v))
 
 	if c.Debug {
 		log.Std.Info("Request API Completed")
 	}
 
 	if c.Debug {
-		log.Debugf("%+v\n", r)
+		log.Debugf("%s\n", r)
 	}
 
 	if err != nil {
-		j, _ := json.Marshal(v)
-		vStr := string(j)
-		return nil, errors.New(" call api error " + path + ", body : " + vStr + ", reason : " + err.Error() )
+		return nil, c.callError(path, v, err)
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
+	resp := gjson.ParseBytes(r)
 
 	result := resp
 
@@ -108,48 +114,98 @@ func (c *Client) PostStringCall(path string, v string) (*gjson.Result, error) {
 		"Content-Type":        "application/json",
 	}
 
-	r, err := req.Post(c.BaseURL+path, v, header)
+	r, err := c.post(path, v, header)
 
 	if c.Debug {
 		log.Std.Info("Request API Completed")
 	}
 
 	if c.Debug {
-		log.Debugf("%+v\n", r)
+		log.Debugf("%s\n", r)
 	}
 
 	if err != nil {
-		j, _ := json.Marshal(v)
-		vStr := string(j)
-		return nil, errors.New(" call api error " + path + ", body : " + vStr + ", reason : " + err.Error() )
+		return nil, c.callError(path, v, err)
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
+	resp := gjson.ParseBytes(r)
 
 	result := resp
 
 	return &result, nil
 }
 
-//从接口返回的json结果，提取data，code一定要等于200，才能返回，不是200，一律视为错误
-func (c *Client) getDataInJson(json *gjson.Result)(*gjson.Result, error){
-	code := gjson.Get(json.Raw, "code").Int()
-	if code!=200 {
-		return nil, errors.New("getBlockHeight return wrong code : " + strconv.FormatInt(code,10) )
+//post 发送请求，配置了多个节点时按优先级依次尝试，当前节点不可用时自动切换
+func (c *Client) post(path string, v ...interface{}) ([]byte, error) {
+	policy := c.Policy.forPath(path)
+	if c.Pool == nil {
+		return policy.post(c.client, c.BaseURL+path, v...)
 	}
 
-	data := gjson.Get(json.Raw, "data")
-	return &data, nil
+	//所有可用节点都失败时，节点池重新检查后再尝试新恢复的节点
+	var lastErr error
+	tried := make(map[string]bool)
+	for round := 0; round < 2; round++ {
+		candidates, err := c.Pool.Candidates()
+		if err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+
+		for _, baseURL := range candidates {
+			if tried[baseURL] {
+				continue
+			}
+			tried[baseURL] = true
+
+			r, err := policy.post(c.client, baseURL+path, v...)
+			if err == nil {
+				c.Pool.SwitchTo(baseURL, "previous node unavailable")
+				return r, nil
+			}
+
+			//节点明确拒绝的请求，换节点也不会成功
+			if statusErr, ok := err.(*HTTPStatusError); ok && !isRetryableStatus(statusErr.StatusCode) {
+				return nil, err
+			}
+
+			c.Pool.MarkFailed(baseURL, err)
+			lastErr = err
+
+			//非幂等的请求不切换节点重发，避免同一交易广播到多个节点
+			if nonIdempotentPaths[path] {
+				return nil, lastErr
+			}
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no node api available")
+	}
+	return nil, lastErr
+}
+
+//callError 非200的HTTP响应转换为节点错误，网络错误保留原始错误
+func (c *Client) callError(path string, v interface{}, err error) error {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return newNodeErrorFromHTTPStatus(path, statusErr)
+	}
+
+	j, _ := json.Marshal(v)
+	return fmt.Errorf(" call api error %s, body : %s, reason : %w", path, string(j), err)
 }
 
-func (c *Client) getDataArrInJson(json *gjson.Result)(*gjson.Result, error){
+//从接口返回的json结果，提取data，code一定要等于200，才能返回，不是200，一律视为错误
+func (c *Client) getDataInJson(path string, json *gjson.Result)(*gjson.Result, error){
 	code := gjson.Get(json.Raw, "code").Int()
 	if code!=200 {
-		return nil, errors.New("getBlockHeight return wrong code : " + strconv.FormatInt(code,10) )
+		return nil, newNodeErrorFromResponse(path, json)
 	}
 
 	data := gjson.Get(json.Raw, "data")
-
 	return &data, nil
 }
 
@@ -163,7 +219,7 @@ func (c *Client) getBlockHeight() (uint64, error) {
 		return 0, err
 	}
 
-	data, err := c.getDataInJson(resp)
+	data, err := c.getDataInJson("/open/block/height", resp)
 	if err != nil {
 		return 0, err
 	}
@@ -187,24 +243,72 @@ func (c *Client) getBalance(address string) (*AddrBalance, error) {
 		return nil, err
 	}
 
-	data, err := c.getDataInJson(resp)
+	data, err := c.getDataInJson("/open/balance", resp)
 	if err != nil {
 		return nil, err
 	}
 
-	balanceStr := gjson.Get(data.Raw, "balance").String()
-	balance := convertFromAmount( balanceStr, c.Decimal)
-
-	balanceBigInt, _ := big.NewInt(0).SetString(strconv.FormatUint(balance,10), 10)
+	balanceStr := amountInJson(gjson.Get(data.Raw, "balance"))
+	balanceBigInt, err := convertFromAmount( balanceStr, c.Decimal)
+	if err != nil {
+		return nil, errors.New("wrong balance of address " + address + " : " + err.Error())
+	}
 	feeFrozen := big.NewInt(0)
+	//链上该地址已使用的最大nonce，节点不支持时为0
+	nonce := gjson.Get(data.Raw, "nonce").Uint()
 
-	return &AddrBalance{Address: address, Balance: balanceBigInt, Freeze: feeFrozen, Free: balanceBigInt, Actived: true, Nonce: 0}, nil
+	return &AddrBalance{Address: address, Balance: balanceBigInt, Freeze: feeFrozen, Free: balanceBigInt, Actived: true, Nonce: nonce}, nil
+}
+
+//getTxFee 由节点计算转账金额的手续费，响应为手续费数值或包含fee字段
+func (c *Client) getTxFee(path string, amount decimal.Decimal) (decimal.Decimal, error) {
+	body := map[string]interface{}{
+		"amount": json.Number(amount.String()),
+	}
+
+	resp, err := c.PostCall(path, body)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	data, err := c.getDataInJson(path, resp)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	feeJson := *data
+	if data.IsObject() {
+		feeJson = data.Get("fee")
+	}
+	fee, err := decimal.NewFromString(amountInJson(feeJson))
+	if err != nil || fee.IsNegative() {
+		return decimal.Zero, errors.New("wrong fee from node : " + data.Raw)
+	}
+	return fee, nil
 }
 
 func (c *Client) getBlockByHeight(height uint64) (*Block, error) {
+	blocks, err := c.getBlocksByRange(height, height)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blocks)>0 {
+		return blocks[0], nil
+	}else{
+		return nil, newNodeError("/open/block/range", http.StatusNotFound, "block not found, height : "+strconv.FormatUint(height, 10))
+	}
+}
+
+// 批量获取区块，返回[start, end]区间内的区块，按高度升序排列
+func (c *Client) getBlocksByRange(start, end uint64) ([]*Block, error) {
+	if start > end {
+		return nil, errors.New("wrong block range, start : " + strconv.FormatUint(start, 10) + ", end : " + strconv.FormatUint(end, 10))
+	}
+
 	body := map[string]interface{}{
-		"start" : height,
-		"end" : height,
+		"start" : start,
+		"end" : end,
 	}
 
 	resp, err := c.PostCall("/open/block/range", body)
@@ -212,49 +316,95 @@ func (c *Client) getBlockByHeight(height uint64) (*Block, error) {
 		return nil, err
 	}
 
-	data, err := c.getDataArrInJson(resp)
+	data, err := c.getDataInJson("/open/block/range", resp)
 	if err != nil {
 		return nil, err
 	}
 
-	dataArr := data.Array()
+	blocks := make([]*Block, 0, len(data.Array()))
+	for _, item := range data.Array() {
+		block := NewBlock(&item, c.Decimal)
+		if block.Height < start || block.Height > end {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
 
-	if len(dataArr)>0 {
-		return NewBlock(&(dataArr[0]), c.Decimal), nil
-	}else{
-		return nil, errors.New("block not found, height : "+strconv.FormatUint(height, 10) )
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Height < blocks[j].Height
+	})
+
+	return blocks, nil
+}
+
+// 获取节点交易池中待打包的交易
+func (c *Client) getPendingTransactions() ([]Transaction, error) {
+	body := map[string]interface{}{
 	}
+
+	resp, err := c.PostCall("/open/tx/pending", body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.getDataInJson("/open/tx/pending", resp)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]Transaction, 0, len(data.Array()))
+	for _, item := range data.Array() {
+		txs = append(txs, NewTransaction(&item, c.Decimal))
+	}
+
+	return txs, nil
 }
 
 func (c *Client) sendTransaction(ts *xbtTransaction.TxStruct) (string, error) {
-	tx := ""
-	tx = tx + `{`
-	tx = tx + "\"tx\":{"
-	tx = tx + "\"hash\":\"" + ts.Hash + "\""
-	tx = tx + ",\"to\":\"" + ts.To + "\""
-	tx = tx + ",\"amount\":" + ts.Amount.String()
-	tx = tx + ",\"fee\":" + ts.Fee.String()
-	tx = tx + ",\"nonce\":" + strconv.FormatUint(ts.Nonce, 10)
-	tx = tx + ",\"time\":" + strconv.FormatUint(ts.Time, 10)
-	tx = tx + ",\"sig\":\"" + ts.Sig + "\""
-	tx = tx + `}`
-	tx = tx + `}`
+	tx, err := xbtTransaction.EncodeSendTxRequest(ts)
+	if err != nil {
+		return "", err
+	}
 
 	log.Debug("sendTransaction tx : ", tx)
 
 	resp, err := c.PostStringCall("/open/tx/send", tx)
-	if err != nil {
-		return "", err
+	if err == nil {
+		log.Debug("sendTransaction result : ", resp)
+
+		code := gjson.Get(resp.Raw, "code").Int()
+		if code==200 {
+			return ts.Hash, nil
+		}
+		err = newNodeErrorFromResponse("/open/tx/send", resp)
 	}
 
-	time.Sleep(time.Duration(1) * time.Second)
+	//网络错误时节点可能已接受交易，nonce重复可能是同一交易已广播过，交易池中有该交易时视为广播成功
+	var nodeErr *NodeError
+	var nonceErr *DuplicateNonceError
+	if (!errors.As(err, &nodeErr) || errors.As(err, &nonceErr)) && c.isTxPending(ts.Hash) {
+		log.Std.Warning("sendTransaction %s returned error but tx is pending in node: %v", ts.Hash, err)
+		return ts.Hash, nil
+	}
+	return "", err
+}
 
-	log.Debug("sendTransaction result : ", resp)
+//isTxPending 交易是否已在节点的交易池中，查询失败时视为不在
+func (c *Client) isTxPending(txid string) bool {
+	pending, err := c.txPending(txid)
+	return err == nil && pending
+}
 
-	code := gjson.Get(resp.Raw, "code").Int()
-	if code==200 {
-		return ts.Hash, nil
-	}else{
-		return "", errors.New( "send tx error : " + resp.String() )
+//txPending 查询交易是否在节点的交易池中
+func (c *Client) txPending(txid string) (bool, error) {
+	txs, err := c.getPendingTransactions()
+	if err != nil {
+		return false, err
+	}
+	for _, tx := range txs {
+		if tx.TxID == txid {
+			return true, nil
+		}
 	}
+	return false, nil
 }
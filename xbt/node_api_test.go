@@ -2,18 +2,14 @@ package xbt
 
 import (
 	"bytes"
-	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"github.com/blocktree/go-owcrypt"
-	"github.com/blocktree/xbt-adapter/xbtTransaction"
-	"github.com/shopspring/decimal"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+	"github.com/tidwall/gjson"
 	"strings"
 	"testing"
 )
 
 const (
-	testNodeAPI = "https://api.xbt.wang" //xbt-api
 	symbol = "XBT"
 	currencyDecimal = 6
 )
@@ -29,12 +25,16 @@ func PrintJsonLog(t *testing.T, logCont string){
 }
 
 func TestPostCall(t *testing.T) {
-	tw := NewClient(testNodeAPI, true, symbol, currencyDecimal)
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(3)
+
+	tw := NewClient(srv.URL, true, symbol, currencyDecimal)
 
 	body := map[string]interface{}{
 	}
 
-	if r, err := tw.PostCall("/open/block/height", body); err != nil {
+	if r, err := tw.PostCall(xbtmock.PathBlockHeight, body); err != nil {
 		t.Errorf("Post Call Result failed: %v\n", err)
 	} else {
 		PrintJsonLog(t, r.String())
@@ -42,72 +42,138 @@ func TestPostCall(t *testing.T) {
 }
 
 func Test_getBlockHeight(t *testing.T) {
-	c := NewClient(testNodeAPI, true, symbol, currencyDecimal)
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(3)
 
-	r, err := c.getBlockHeight()
+	c := NewClient(srv.URL, true, symbol, currencyDecimal)
 
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("height:", r)
+	r, err := c.getBlockHeight()
+	if err != nil || r != srv.Height() {
+		t.Errorf("getBlockHeight = %d, %v, want %d", r, err, srv.Height())
 	}
 }
 
 func Test_getBalance(t *testing.T) {
-
-	c := NewClient(testNodeAPI, true, symbol, currencyDecimal)
+	srv := xbtmock.NewServer()
+	defer srv.Close()
 
 	address := "xB8d4fDbe476Db5F1961Db61fFB39786bF383f0ABE"
+	srv.SetBalance(address, "12.5")
 
-	r, err := c.getBalance(address)
+	c := NewClient(srv.URL, true, symbol, currencyDecimal)
 
+	r, err := c.getBalance(address)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println(r)
+		t.Fatalf("getBalance failed: %v", err)
+	}
+	if r.Balance.String() != "12500000" {
+		t.Errorf("getBalance = %s, want 12500000", r.Balance.String())
 	}
 }
 
 func Test_sendTransaction(t *testing.T){
-
-	prikey, _ := hex.DecodeString("xxx")
-	pubkey, _ := hex.DecodeString("xxx")
+	srv := xbtmock.NewServer()
+	defer srv.Close()
 
 	to := "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B"
-	amount, _ := decimal.NewFromString("0.01234")
-	fee, _ := decimal.NewFromString("0.1")
+	ts, _ := testSignedTx(t, to, "0.01234")
 
-	txStruct, hash, err := xbtTransaction.GetTxStruct(to, &amount, &fee)
+	c := NewClient(srv.URL, true, symbol, currencyDecimal)
+	r, err := c.sendTransaction( ts )
 	if err != nil {
-		return
+		t.Fatalf("sendTransaction failed: %v", err)
 	}
-	emptyTrans := txStruct.ToJSONString()
-
-	signature, _, retCode := owcrypt.Signature(prikey, nil, hash, owcrypt.ECC_CURVE_SECP256K1)
-	if retCode!=owcrypt.SUCCESS {
-		fmt.Println("error")
-		return
+	if r != ts.Hash {
+		t.Errorf("sendTransaction = %s, want %s", r, ts.Hash)
 	}
+}
 
-	signedTrans, _ := xbtTransaction.VerifyAndCombineTransaction(emptyTrans, hex.EncodeToString(signature), pubkey)
-
-	ts, err := xbtTransaction.NewTxStructFromJSON(signedTrans)
+func Test_getBlockByHeight(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(3)
 
-	c := NewClient(testNodeAPI, true, symbol, currencyDecimal)
-	r, err := c.sendTransaction( ts )
+	c := NewClient(srv.URL, true, symbol, currencyDecimal)
+	r, err := c.getBlockByHeight(2)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println(r)
+		t.Fatalf("getBlockByHeight failed: %v", err)
+	}
+	if r.Height != 2 || r.Hash != srv.GetBlock(2).Hash || r.PrevBlockHash != srv.GetBlock(1).Hash {
+		t.Errorf("unexpected block: %+v", r)
 	}
 }
 
-func Test_getBlockByHeight(t *testing.T) {
-	c := NewClient(testNodeAPI, true, symbol, currencyDecimal)
-	r, err := c.getBlockByHeight(307757)
+func Test_getBlocksByRange(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(10)
+
+	c := NewClient(srv.URL, true, symbol, currencyDecimal)
+	r, err := c.getBlocksByRange(2, 9)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println(r)
+		t.Fatalf("getBlocksByRange failed: %v", err)
+	}
+	if len(r) != 8 {
+		t.Fatalf("getBlocksByRange returned %d blocks, want 8", len(r))
+	}
+	for i, b := range r {
+		if b.Height != uint64(i+2) || b.Hash != srv.GetBlock(b.Height).Hash {
+			t.Errorf("unexpected block: %d %s", b.Height, b.Hash)
+		}
+	}
+}
+
+func Test_parseBlockTime(t *testing.T) {
+	tests := []struct {
+		json string
+		want uint64
+	}{
+		{`{"time":1618531200}`, 1618531200},
+		{`{"time":1618531200123}`, 1618531200},
+		{`{"time":1618531200123456}`, 1618531200},
+		{`{"time":"1618531200123"}`, 1618531200},
+		{`{"time":"2021-04-16T00:00:00Z"}`, 1618531200},
+		{`{}`, 0},
+	}
+
+	for _, test := range tests {
+		if got := parseBlockTime(gjson.Get(test.json, "time")); got != test.want {
+			t.Errorf("parseBlockTime(%s) = %d, want %d", test.json, got, test.want)
+		}
+	}
+}
+
+func TestNewBlock_TxTime(t *testing.T) {
+	tests := []struct {
+		json string
+		want uint64
+	}{
+		{`{"hash":"h","height":1,"time":1600000000,"tx":[{"hash":"a","amount":"1","time":1500000000000}]}`, 1600000000},
+		//区块没有时间时使用交易的时间
+		{`{"hash":"h","height":1,"tx":[{"hash":"a","amount":"1","time":1500000000000}]}`, 1500000000},
+	}
+	for _, test := range tests {
+		result := gjson.Parse(test.json)
+		block := NewBlock(&result, currencyDecimal)
+		if len(block.Transactions) != 1 || block.Transactions[0].TimeStamp != test.want {
+			t.Errorf("NewBlock(%s) tx time = %+v, want %d", test.json, block.Transactions, test.want)
+		}
+	}
+}
+
+func Test_convertFromAmount(t *testing.T) {
+	amount, err := convertFromAmount("98765432109876543210.123456", currencyDecimal)
+	if err != nil || amount.String() != "98765432109876543210123456" {
+		t.Errorf("convertFromAmount = %v, %v", amount, err)
+	}
+	if s := convertToAmount(amount, currencyDecimal); s != "98765432109876543210.123456" {
+		t.Errorf("convertToAmount = %s", s)
 	}
-}
\ No newline at end of file
+
+	for _, wrong := range []string{"", "abc", "-1", "0.1234567"} {
+		if _, err := convertFromAmount(wrong, currencyDecimal); err == nil {
+			t.Errorf("convertFromAmount(%q) should fail", wrong)
+		}
+	}
+}
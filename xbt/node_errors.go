@@ -0,0 +1,165 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+//NodeError 节点接口返回的错误，具体的错误类型可以通过errors.As判断
+type NodeError struct {
+	Path    string //接口路径
+	Code    int64  //响应中的code，没有时为HTTP状态码
+	Message string //响应中的message
+}
+
+func (e *NodeError) Error() string {
+	return "node api " + e.Path + " return code " + strconv.FormatInt(e.Code, 10) + " : " + e.Message
+}
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *NodeError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrUnknownException, "%s", e.Error())
+}
+
+//NotFoundError 区块、交易或地址不存在
+type NotFoundError struct{ NodeError }
+
+func (e *NotFoundError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *NotFoundError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "%s", e.Error())
+}
+
+//InvalidSignatureError 交易签名无效
+type InvalidSignatureError struct{ NodeError }
+
+func (e *InvalidSignatureError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *InvalidSignatureError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "%s", e.Error())
+}
+
+//InsufficientBalanceError 地址余额不足
+type InsufficientBalanceError struct{ NodeError }
+
+func (e *InsufficientBalanceError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *InsufficientBalanceError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAddress, "%s", e.Error())
+}
+
+//DuplicateNonceError nonce已被使用
+type DuplicateNonceError struct{ NodeError }
+
+func (e *DuplicateNonceError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *DuplicateNonceError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrNonceInvaild, "%s", e.Error())
+}
+
+//RateLimitedError 请求过于频繁
+type RateLimitedError struct{ NodeError }
+
+func (e *RateLimitedError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *RateLimitedError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrNetworkRequestFailed, "%s", e.Error())
+}
+
+//ServerError 节点内部错误
+type ServerError struct{ NodeError }
+
+func (e *ServerError) Unwrap() error { return &e.NodeError }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *ServerError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "%s", e.Error())
+}
+
+//newNodeError 根据code和message判断错误类型，message优先于code，只匹配明确的错误描述，避免误判
+func newNodeError(path string, code int64, message string) error {
+	base := NodeError{Path: path, Code: code, Message: message}
+	msg := strings.ToLower(message)
+
+	switch {
+	case code == http.StatusTooManyRequests || containsAny(msg, "rate limit", "too many"):
+		return &RateLimitedError{base}
+	case containsAny(msg, "invalid signature", "invalid sig", "signature verify failed", "signature verification failed"):
+		return &InvalidSignatureError{base}
+	case containsAny(msg, "insufficient balance", "insufficient funds", "balance not enough", "not enough balance"):
+		return &InsufficientBalanceError{base}
+	case containsAny(msg, "nonce already used", "nonce has been used", "duplicate nonce"):
+		return &DuplicateNonceError{base}
+	case code == http.StatusNotFound || containsAny(msg, "not found", "not exist"):
+		return &NotFoundError{base}
+	case code >= http.StatusInternalServerError:
+		return &ServerError{base}
+	}
+
+	return &base
+}
+
+//newNodeErrorFromResponse 解析响应中的code和message
+func newNodeErrorFromResponse(path string, resp *gjson.Result) error {
+	return newNodeError(path, resp.Get("code").Int(), resp.Get("message").String())
+}
+
+//newNodeErrorFromHTTPStatus 非200的HTTP响应，响应内容中有message时一并解析
+func newNodeErrorFromHTTPStatus(path string, statusErr *HTTPStatusError) error {
+	message := gjson.GetBytes(statusErr.Body, "message").String()
+	if len(message) == 0 {
+		message = strings.TrimSpace(string(statusErr.Body))
+	}
+	return newNodeError(path, int64(statusErr.StatusCode), message)
+}
+
+//ToOpenwalletError 节点错误转换为对应的openwallet错误码，其他错误使用defaultCode
+func ToOpenwalletError(err error, defaultCode uint64) *openwallet.Error {
+	var owErr *openwallet.Error
+	if errors.As(err, &owErr) {
+		return owErr
+	}
+
+	var nodeErr interface {
+		OpenwalletError() *openwallet.Error
+	}
+	if errors.As(err, &nodeErr) {
+		return nodeErr.OpenwalletError()
+	}
+
+	return openwallet.Errorf(defaultCode, "%s", err.Error())
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package xbt
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+func TestNewNodeError(t *testing.T) {
+	tests := []struct {
+		code    int64
+		message string
+		check   func(err error) bool
+		owCode  uint64
+	}{
+		{404, "", func(err error) bool { var e *NotFoundError; return errors.As(err, &e) }, openwallet.ErrCallFullNodeAPIFailed},
+		{400, "Invalid Signature", func(err error) bool { var e *InvalidSignatureError; return errors.As(err, &e) }, openwallet.ErrVerifyRawTransactionFailed},
+		{400, "insufficient balance", func(err error) bool { var e *InsufficientBalanceError; return errors.As(err, &e) }, openwallet.ErrInsufficientBalanceOfAddress},
+		{400, "nonce already used", func(err error) bool { var e *DuplicateNonceError; return errors.As(err, &e) }, openwallet.ErrNonceInvaild},
+		{429, "", func(err error) bool { var e *RateLimitedError; return errors.As(err, &e) }, openwallet.ErrNetworkRequestFailed},
+		{503, "maintenance", func(err error) bool { var e *ServerError; return errors.As(err, &e) }, openwallet.ErrCallFullNodeAPIFailed},
+		{400, "bad request", func(err error) bool { var e *NodeError; return errors.As(err, &e) }, openwallet.ErrUnknownException},
+		//只包含关键字的其他错误不归类
+		{400, "invalid nonce format", func(err error) bool { var e *DuplicateNonceError; return !errors.As(err, &e) }, openwallet.ErrUnknownException},
+		{400, "balance query timeout", func(err error) bool { var e *InsufficientBalanceError; return !errors.As(err, &e) }, openwallet.ErrUnknownException},
+		{400, "signature is required", func(err error) bool { var e *InvalidSignatureError; return !errors.As(err, &e) }, openwallet.ErrUnknownException},
+		{400, "Insufficient funds for fee", func(err error) bool { var e *InsufficientBalanceError; return errors.As(err, &e) }, openwallet.ErrInsufficientBalanceOfAddress},
+	}
+
+	for _, test := range tests {
+		err := newNodeError("/open/tx/send", test.code, test.message)
+		if !test.check(err) {
+			t.Errorf("code %d message %q: unexpected error type %T", test.code, test.message, err)
+		}
+
+		var nodeErr *NodeError
+		if !errors.As(err, &nodeErr) || nodeErr.Message != test.message {
+			t.Errorf("code %d message %q: NodeError not found in %v", test.code, test.message, err)
+		}
+
+		if owErr := ToOpenwalletError(err, openwallet.ErrSubmitRawTransactionFailed); owErr.Code() != test.owCode {
+			t.Errorf("code %d message %q: openwallet code = %d, want %d", test.code, test.message, owErr.Code(), test.owCode)
+		}
+	}
+}
+
+func TestClient_NodeError_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(2)
+
+	c := NewClient(srv.URL, false, symbol, currencyDecimal)
+	c.Policy = &TransportPolicy{Timeout: defaultRequestTimeout, MaxAttempts: 1}
+
+	srv.InjectFault(xbtmock.PathBalance, xbtmock.Fault{Code: 500, Message: "database unavailable", Times: 1})
+	_, err := c.getBalance(testDepositAddress)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Path != "/open/balance" {
+		t.Errorf("getBalance error = %v, want ServerError", err)
+	}
+
+	srv.InjectFault(xbtmock.PathBlockRange, xbtmock.Fault{HTTPStatus: http.StatusTooManyRequests, Message: "slow down", Times: 1})
+	_, err = c.getBlockByHeight(1)
+	var rateErr *RateLimitedError
+	if !errors.As(err, &rateErr) || rateErr.Message != "slow down" {
+		t.Errorf("getBlockByHeight error = %v, want RateLimitedError", err)
+	}
+
+	_, err = c.getBlockByHeight(10)
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Errorf("getBlockByHeight error = %v, want NotFoundError", err)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+)
+
+const (
+	NonceStrategySequential = "sequential" //每个发送地址的nonce递增，与链上已使用的nonce核对
+	NonceStrategyRandom     = "random"     //随机nonce，只有链上允许非递增的nonce时使用
+)
+
+//NonceManager 发送地址的nonce管理，已使用的nonce保存在WalletDAI的地址扩展参数中
+type NonceManager struct {
+	Strategy string
+	Key      string //地址扩展参数的key
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex //每个地址一个锁，保证并发创建交易时nonce不重复
+	last  map[string]uint64      //本进程内每个地址最后使用的nonce
+	used  map[string]bool        //随机策略下本进程已使用的nonce
+}
+
+//NewNonceManager 创建nonce管理器，未知的策略返回错误
+func NewNonceManager(strategy, symbol string) (*NonceManager, error) {
+	switch strategy {
+	case "":
+		strategy = NonceStrategySequential
+	case NonceStrategySequential, NonceStrategyRandom:
+	default:
+		return nil, fmt.Errorf("unknown nonce strategy: %s", strategy)
+	}
+
+	return &NonceManager{
+		Strategy: strategy,
+		Key:      symbol + "-nonce",
+		locks:    make(map[string]*sync.Mutex),
+		last:     make(map[string]uint64),
+		used:     make(map[string]bool),
+	}, nil
+}
+
+//addressLock 获取地址的锁
+func (m *NonceManager) addressLock(address string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[address]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[address] = lock
+	}
+	return lock
+}
+
+//NextNonce 分配地址的下一个nonce，chainNonce为链上该地址已使用的最大nonce（节点不支持时为0）
+//取本地记录与链上nonce的较大值加1，分配后立即保存，保存失败时不使用该nonce
+func (m *NonceManager) NextNonce(wrapper openwallet.WalletDAI, address string, chainNonce uint64) (uint64, error) {
+	if m.Strategy == NonceStrategyRandom {
+		return m.randomNonce(address), nil
+	}
+
+	lock := m.addressLock(address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	local := m.localNonce(wrapper, address)
+
+	nonce := local
+	if chainNonce > nonce {
+		//其他服务使用该地址发送过交易，以链上为准
+		nonce = chainNonce
+	}
+	nonce++
+
+	value := strconv.FormatUint(nonce, 10) + "_" + strconv.FormatInt(time.Now().Unix(), 10)
+	err := wrapper.SetAddressExtParam(address, m.Key, value)
+	if err != nil {
+		return 0, fmt.Errorf("save nonce of address %s failed: %v", address, err)
+	}
+
+	m.mu.Lock()
+	m.last[address] = nonce
+	m.mu.Unlock()
+
+	return nonce, nil
+}
+
+//Release 交易单创建失败时释放已分配的nonce，nonce仍是该地址最后分配的nonce时回退，之后已分配其他nonce时不处理
+func (m *NonceManager) Release(wrapper openwallet.WalletDAI, address string, nonce uint64) error {
+	if m.Strategy == NonceStrategyRandom {
+		m.mu.Lock()
+		delete(m.used, address+"_"+strconv.FormatUint(nonce, 10))
+		m.mu.Unlock()
+		return nil
+	}
+
+	lock := m.addressLock(address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if nonce == 0 || m.localNonce(wrapper, address) != nonce {
+		return nil
+	}
+
+	value := strconv.FormatUint(nonce-1, 10) + "_" + strconv.FormatInt(time.Now().Unix(), 10)
+	err := wrapper.SetAddressExtParam(address, m.Key, value)
+	if err != nil {
+		return fmt.Errorf("release nonce of address %s failed: %v", address, err)
+	}
+
+	m.mu.Lock()
+	m.last[address] = nonce - 1
+	m.mu.Unlock()
+
+	return nil
+}
+
+//localNonce 本地记录的最后使用的nonce，取内存与WalletDAI中的较大值
+func (m *NonceManager) localNonce(wrapper openwallet.WalletDAI, address string) uint64 {
+	m.mu.Lock()
+	nonce := m.last[address]
+	m.mu.Unlock()
+
+	saved, err := wrapper.GetAddressExtParam(address, m.Key)
+	if err != nil || saved == nil {
+		return nonce
+	}
+
+	//保存格式：nonce_保存时间
+	value := strings.Split(common.NewString(saved).String(), "_")[0]
+	dbNonce, err := strconv.ParseUint(value, 10, 64)
+	if err == nil && dbNonce > nonce {
+		nonce = dbNonce
+	}
+	return nonce
+}
+
+//randomNonce 随机策略，本进程内不重复
+func (m *NonceManager) randomNonce(address string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		nonce := xbtTransaction.RandomNonce()
+		key := address + "_" + strconv.FormatUint(nonce, 10)
+		if !m.used[key] {
+			m.used[key] = true
+			return nonce
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package xbt
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testNonceDAI 以map保存地址扩展参数
+type testNonceDAI struct {
+	openwallet.WalletDAIBase
+	mu      sync.Mutex
+	params  map[string]interface{}
+	failSet bool
+}
+
+func newTestNonceDAI() *testNonceDAI {
+	return &testNonceDAI{params: make(map[string]interface{})}
+}
+
+func (d *testNonceDAI) SetAddressExtParam(address string, key string, val interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failSet {
+		return errors.New("db unavailable")
+	}
+	d.params[address+key] = val
+	return nil
+}
+
+func (d *testNonceDAI) GetAddressExtParam(address string, key string) (interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.params[address+key], nil
+}
+
+func TestNonceManager_Sequential(t *testing.T) {
+	dai := newTestNonceDAI()
+	m, err := NewNonceManager("", "XBT")
+	if err != nil {
+		t.Fatalf("NewNonceManager failed: %v", err)
+	}
+	if m.Strategy != NonceStrategySequential {
+		t.Fatalf("default strategy = %s", m.Strategy)
+	}
+
+	for want := uint64(1); want <= 3; want++ {
+		got, err := m.NextNonce(dai, "xB01", 0)
+		if err != nil {
+			t.Fatalf("NextNonce failed: %v", err)
+		}
+		if got != want {
+			t.Fatalf("nonce = %d, want %d", got, want)
+		}
+	}
+
+	//链上nonce更大时以链上为准
+	got, _ := m.NextNonce(dai, "xB01", 10)
+	if got != 11 {
+		t.Fatalf("nonce = %d, want 11", got)
+	}
+
+	//其他地址独立计数
+	got, _ = m.NextNonce(dai, "xB02", 0)
+	if got != 1 {
+		t.Fatalf("nonce of other address = %d, want 1", got)
+	}
+
+	//重启后从WalletDAI保存的nonce继续
+	restarted, _ := NewNonceManager(NonceStrategySequential, "XBT")
+	got, _ = restarted.NextNonce(dai, "xB01", 0)
+	if got != 12 {
+		t.Fatalf("nonce after restart = %d, want 12", got)
+	}
+}
+
+func TestNonceManager_Release(t *testing.T) {
+	dai := newTestNonceDAI()
+	m, _ := NewNonceManager("", "XBT")
+
+	m.NextNonce(dai, "xB01", 0)
+	nonce, _ := m.NextNonce(dai, "xB01", 0)
+	if err := m.Release(dai, "xB01", nonce); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if got, _ := m.NextNonce(dai, "xB01", 0); got != nonce {
+		t.Fatalf("nonce after release = %d, want %d", got, nonce)
+	}
+
+	//已分配更大的nonce时不回退
+	next, _ := m.NextNonce(dai, "xB01", 0)
+	m.Release(dai, "xB01", nonce)
+	if got, _ := m.NextNonce(dai, "xB01", 0); got != next+1 {
+		t.Fatalf("nonce = %d, want %d", got, next+1)
+	}
+}
+
+func TestNonceManager_SaveFailed(t *testing.T) {
+	dai := newTestNonceDAI()
+	dai.failSet = true
+	m, _ := NewNonceManager(NonceStrategySequential, "XBT")
+
+	if _, err := m.NextNonce(dai, "xB01", 0); err == nil {
+		t.Fatal("NextNonce should fail when nonce can not be saved")
+	}
+
+	dai.failSet = false
+	got, _ := m.NextNonce(dai, "xB01", 0)
+	if got != 1 {
+		t.Fatalf("nonce = %d, want 1", got)
+	}
+}
+
+func TestNonceManager_Concurrent(t *testing.T) {
+	for _, strategy := range []string{NonceStrategySequential, NonceStrategyRandom} {
+		dai := newTestNonceDAI()
+		m, _ := NewNonceManager(strategy, "XBT")
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			seen = make(map[uint64]bool)
+		)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				nonce, err := m.NextNonce(dai, "xB01", 0)
+				if err != nil {
+					t.Errorf("NextNonce failed: %v", err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[nonce] {
+					t.Errorf("%s: duplicate nonce %d", strategy, nonce)
+				}
+				seen[nonce] = true
+			}()
+		}
+		wg.Wait()
+
+		if strategy == NonceStrategySequential {
+			for n := uint64(1); n <= 50; n++ {
+				if !seen[n] {
+					t.Fatalf("nonce %d not allocated", n)
+				}
+			}
+		}
+	}
+}
+
+func TestNewNonceManager_UnknownStrategy(t *testing.T) {
+	if _, err := NewNonceManager("increasing", "XBT"); err == nil {
+		t.Fatal("unknown strategy should return error")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxScanLag       = 20
+	defaultScanStallTimeout = 5 * time.Minute
+	scanRateWindow          = 5 * time.Minute //计算每分钟扫描区块数的时间窗口
+)
+
+//ScannerStatus 扫描器的运行状态
+type ScannerStatus struct {
+	Symbol            string    `json:"symbol"`
+	LocalHeight       uint64    `json:"localHeight"`       //本地已扫高度
+	NodeHeight        uint64    `json:"nodeHeight"`        //节点最新高度
+	Lag               uint64    `json:"lag"`               //落后节点的区块数
+	LastBlockTime     uint64    `json:"lastBlockTime"`     //最近扫描区块的出块时间
+	LastScanTime      time.Time `json:"lastScanTime"`      //最近扫描到新区块的时间
+	BlocksPerMinute   float64   `json:"blocksPerMinute"`   //最近5分钟平均每分钟扫描的区块数
+	UnscanRecords     int       `json:"unscanRecords"`     //未扫记录数
+	DeadUnscanRecords int       `json:"deadUnscanRecords"` //不再自动重扫的未扫记录数
+	ForkCount         uint64    `json:"forkCount"`         //启动后处理的分叉次数
+	ExtractFailures   uint64    `json:"extractFailures"`   //启动后提取失败的交易数
+	Scanning          bool      `json:"scanning"`
+	Stalled           bool      `json:"stalled"` //节点有新区块，但超过StallTimeout没有扫描进度
+	Lagging           bool      `json:"lagging"` //落后节点超过MaxScanLag
+	NodeError         string    `json:"nodeError,omitempty"`
+}
+
+//scannerMetrics 扫描过程中累计的指标
+type scannerMetrics struct {
+	mu              sync.Mutex
+	startTime       time.Time
+	nodeHeight      uint64
+	lastBlockTime   uint64
+	lastScanTime    time.Time
+	scanTimes       []time.Time //时间窗口内每个区块的扫描时间
+	forkCount       uint64
+	extractFailures uint64
+}
+
+func newScannerMetrics() *scannerMetrics {
+	return &scannerMetrics{startTime: time.Now()}
+}
+
+func (m *scannerMetrics) setNodeHeight(height uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodeHeight = height
+}
+
+func (m *scannerMetrics) blockScanned(block *Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.lastBlockTime = block.Timestamp
+	m.lastScanTime = now
+	m.scanTimes = append(m.scanTimes, now)
+	m.pruneScanTimes(now)
+}
+
+func (m *scannerMetrics) forked() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forkCount++
+}
+
+func (m *scannerMetrics) extractFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.extractFailures++
+}
+
+//pruneScanTimes 移除时间窗口之外的扫描记录
+func (m *scannerMetrics) pruneScanTimes(now time.Time) {
+	i := 0
+	for i < len(m.scanTimes) && now.Sub(m.scanTimes[i]) > scanRateWindow {
+		i++
+	}
+	m.scanTimes = m.scanTimes[i:]
+}
+
+//ScannerStatus 获取扫描器的运行状态，节点不可用时使用最近一次获取的节点高度并记录错误
+func (bs *XBTBlockScanner) ScannerStatus() *ScannerStatus {
+	status := &ScannerStatus{
+		Symbol:   bs.wm.Symbol(),
+		Scanning: bs.Scanning,
+	}
+
+	nodeHeight, err := bs.wm.GetBlockHeight()
+	if err != nil {
+		status.NodeError = err.Error()
+	} else {
+		bs.metrics.setNodeHeight(nodeHeight)
+	}
+
+	status.LocalHeight, _, _ = bs.GetLocalBlockHead()
+
+	records, err := bs.GetUnscanRecords()
+	if err == nil {
+		status.UnscanRecords = len(records)
+		for _, r := range records {
+			if parseUnscanReason(r).Dead {
+				status.DeadUnscanRecords++
+			}
+		}
+	}
+
+	m := bs.metrics
+	m.mu.Lock()
+	now := time.Now()
+	m.pruneScanTimes(now)
+	status.NodeHeight = m.nodeHeight
+	status.LastBlockTime = m.lastBlockTime
+	status.LastScanTime = m.lastScanTime
+	status.ForkCount = m.forkCount
+	status.ExtractFailures = m.extractFailures
+	//启动不足5分钟时按已运行时间计算，至少按1分钟计算
+	window := now.Sub(m.startTime)
+	if window > scanRateWindow {
+		window = scanRateWindow
+	} else if window < time.Minute {
+		window = time.Minute
+	}
+	status.BlocksPerMinute = float64(len(m.scanTimes)) / window.Minutes()
+	lastProgress := m.lastScanTime
+	if lastProgress.IsZero() {
+		lastProgress = m.startTime
+	}
+	m.mu.Unlock()
+
+	if status.NodeHeight > status.LocalHeight {
+		status.Lag = status.NodeHeight - status.LocalHeight
+		status.Stalled = bs.StallTimeout > 0 && now.Sub(lastProgress) > bs.StallTimeout
+	}
+	status.Lagging = bs.MaxScanLag > 0 && status.Lag > bs.MaxScanLag
+
+	return status
+}
+
+//WritePrometheus 以Prometheus文本格式输出扫描器的指标
+func (bs *XBTBlockScanner) WritePrometheus(w io.Writer) error {
+	status := bs.ScannerStatus()
+
+	lastScanTime := int64(0)
+	if !status.LastScanTime.IsZero() {
+		lastScanTime = status.LastScanTime.Unix()
+	}
+
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value interface{}
+	}{
+		{"local_height", "gauge", "Height of the last scanned block.", status.LocalHeight},
+		{"node_height", "gauge", "Latest block height of the node.", status.NodeHeight},
+		{"lag_blocks", "gauge", "Number of blocks the scanner is behind the node.", status.Lag},
+		{"last_block_time_seconds", "gauge", "Block time of the last scanned block.", status.LastBlockTime},
+		{"last_scan_time_seconds", "gauge", "Unix time when the last block was scanned.", lastScanTime},
+		{"blocks_per_minute", "gauge", "Blocks scanned per minute over the last 5 minutes.", status.BlocksPerMinute},
+		{"unscan_records", "gauge", "Number of unscan records waiting to be rescanned.", status.UnscanRecords},
+		{"dead_unscan_records", "gauge", "Number of unscan records that reached the max rescan attempts.", status.DeadUnscanRecords},
+		{"forks_total", "counter", "Number of forks handled since start.", status.ForkCount},
+		{"extract_failures_total", "counter", "Number of transactions failed to extract since start.", status.ExtractFailures},
+		{"scanning", "gauge", "Whether the scanner is running.", boolToInt(status.Scanning)},
+		{"stalled", "gauge", "Whether the scanner made no progress within the stall timeout while the node has new blocks.", boolToInt(status.Stalled)},
+		{"lagging", "gauge", "Whether the lag exceeds the configured max scan lag.", boolToInt(status.Lagging)},
+		{"node_up", "gauge", "Whether the node answered the last height request.", boolToInt(len(status.NodeError) == 0)},
+	}
+
+	for _, m := range metrics {
+		name := "xbt_scanner_" + m.name
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{symbol=%q} %v\n", name, m.help, name, m.kind, name, status.Symbol, m.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//MetricsHandler 提供Prometheus抓取指标的http.Handler
+func (bs *XBTBlockScanner) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := bs.WritePrometheus(w); err != nil {
+			bs.wm.Log.Std.Error("write scanner metrics failed; unexpected error: %v", err)
+		}
+	})
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
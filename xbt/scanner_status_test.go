@@ -0,0 +1,93 @@
+package xbt
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+func TestXBTBlockScanner_ScannerStatus_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(3)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1.1234567"})
+	srv.AddBlocks(2)
+
+	wm := testMockWalletManager(t, srv, "maxScanLag = 2", "scanStallTimeout = \"1m\"")
+	bs := wm.Blockscanner
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	srv.Fork(6)
+	srv.AddBlocks(2)
+	bs.ScanBlockTask()
+
+	status := bs.ScannerStatus()
+	if status.LocalHeight != 7 || status.NodeHeight != 7 || status.Lag != 0 {
+		t.Errorf("unexpected heights: %+v", status)
+	}
+	if status.LastBlockTime != srv.GetBlock(7).Time || status.LastScanTime.IsZero() || status.BlocksPerMinute <= 0 {
+		t.Errorf("unexpected scan progress: %+v", status)
+	}
+	if status.ForkCount != 1 || status.ExtractFailures == 0 || status.UnscanRecords == 0 {
+		t.Errorf("unexpected counters: %+v", status)
+	}
+	if status.Stalled || status.Lagging || len(status.NodeError) > 0 {
+		t.Errorf("unexpected health: %+v", status)
+	}
+
+	//节点出新块后没有扫描
+	srv.AddBlocks(3)
+	bs.StallTimeout = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	status = bs.ScannerStatus()
+	if status.Lag != 3 || !status.Stalled || !status.Lagging {
+		t.Errorf("scanner should be stalled and lagging: %+v", status)
+	}
+
+	//节点不可用时保留最近一次的节点高度
+	srv.InjectFault(xbtmock.PathBlockHeight, xbtmock.Fault{HTTPStatus: http.StatusBadRequest})
+	status = bs.ScannerStatus()
+	if len(status.NodeError) == 0 || status.NodeHeight != 10 {
+		t.Errorf("unexpected status when node is down: %+v", status)
+	}
+}
+
+func TestXBTBlockScanner_WritePrometheus_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(4)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	var buf bytes.Buffer
+	if err := bs.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+
+	for _, line := range []string{
+		"# TYPE xbt_scanner_local_height gauge",
+		`xbt_scanner_local_height{symbol="XBT"} 4`,
+		`xbt_scanner_lag_blocks{symbol="XBT"} 0`,
+		"# TYPE xbt_scanner_forks_total counter",
+		`xbt_scanner_stalled{symbol="XBT"} 0`,
+		`xbt_scanner_node_up{symbol="XBT"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, text)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+const (
+	SenderStrategyLargestFirst       = "largest"    //余额最大的地址优先
+	SenderStrategySmallestSufficient = "smallest"   //余额足够支付的地址中，余额最小的优先
+	SenderStrategyRoundRobin         = "roundrobin" //按地址顺序轮流使用余额足够的地址
+)
+
+//SenderSelector 发送地址选择器
+type SenderSelector struct {
+	Strategy string
+
+	mu          sync.Mutex
+	lastAddress string //轮询策略上一次使用的地址
+}
+
+//NewSenderSelector 创建发送地址选择器，未知的策略返回错误
+func NewSenderSelector(strategy string) (*SenderSelector, error) {
+	switch strategy {
+	case "":
+		strategy = SenderStrategyLargestFirst
+	case SenderStrategyLargestFirst, SenderStrategySmallestSufficient, SenderStrategyRoundRobin:
+	default:
+		return nil, fmt.Errorf("unknown sender strategy: %s", strategy)
+	}
+
+	return &SenderSelector{Strategy: strategy}, nil
+}
+
+//spendable 地址的可用余额
+func (a *AddrBalance) spendable() *big.Int {
+	if a.Free != nil {
+		return a.Free
+	}
+	return a.Balance
+}
+
+//Select 从地址列表中选出可用余额不少于need的地址，没有则返回nil
+func (s *SenderSelector) Select(list []*AddrBalance, need *big.Int) *AddrBalance {
+	candidates := make([]*AddrBalance, 0, len(list))
+	for _, a := range list {
+		if a.spendable().Cmp(need) >= 0 {
+			candidates = append(candidates, a)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch s.Strategy {
+	case SenderStrategySmallestSufficient:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].spendable().Cmp(candidates[j].spendable()) < 0
+		})
+		return candidates[0]
+	case SenderStrategyRoundRobin:
+		return s.selectRoundRobin(candidates)
+	default:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].spendable().Cmp(candidates[j].spendable()) > 0
+		})
+		return candidates[0]
+	}
+}
+
+//selectRoundRobin 按地址排序，选择上一次使用地址之后的第一个地址
+func (s *SenderSelector) selectRoundRobin(candidates []*AddrBalance) *AddrBalance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Address < candidates[j].Address
+	})
+
+	selected := candidates[0]
+	for _, a := range candidates {
+		if a.Address > s.lastAddress {
+			selected = a
+			break
+		}
+	}
+
+	s.lastAddress = selected.Address
+	return selected
+}
@@ -0,0 +1,49 @@
+package xbt
+
+import (
+	"math/big"
+	"testing"
+)
+
+func testAddrBalances() []*AddrBalance {
+	return []*AddrBalance{
+		{Address: "xB01", Balance: big.NewInt(500), Free: big.NewInt(500)},
+		{Address: "xB02", Balance: big.NewInt(100), Free: big.NewInt(100)},
+		{Address: "xB03", Balance: big.NewInt(300), Free: big.NewInt(300)},
+		{Address: "xB04", Balance: big.NewInt(900), Free: big.NewInt(50)},
+	}
+}
+
+func TestSenderSelector_Select(t *testing.T) {
+	cases := []struct {
+		strategy string
+		need     int64
+		want     []string
+	}{
+		{SenderStrategyLargestFirst, 200, []string{"xB01", "xB01"}},
+		{SenderStrategySmallestSufficient, 200, []string{"xB03", "xB03"}},
+		{SenderStrategyRoundRobin, 200, []string{"xB01", "xB03", "xB01"}},
+		{SenderStrategyLargestFirst, 600, []string{""}},
+	}
+
+	for _, c := range cases {
+		s, err := NewSenderSelector(c.strategy)
+		if err != nil {
+			t.Fatalf("NewSenderSelector failed: %v", err)
+		}
+		for i, want := range c.want {
+			got := s.Select(testAddrBalances(), big.NewInt(c.need))
+			gotAddress := ""
+			if got != nil {
+				gotAddress = got.Address
+			}
+			if gotAddress != want {
+				t.Errorf("strategy %s round %d: got %s, want %s", c.strategy, i, gotAddress, want)
+			}
+		}
+	}
+
+	if _, err := NewSenderSelector("unknown"); err == nil {
+		t.Errorf("expected error for unknown strategy")
+	}
+}
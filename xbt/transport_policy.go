@@ -0,0 +1,157 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/imroc/req"
+)
+
+const (
+	defaultRequestTimeout     = 15 * time.Second
+	defaultRequestMaxAttempts = 3
+	defaultRequestBackoff     = 500 * time.Millisecond
+	defaultRequestMaxBackoff  = 10 * time.Second
+)
+
+//nonIdempotentPaths 重复请求会产生副作用的接口，失败后不重试，也不切换节点重发
+var nonIdempotentPaths = map[string]bool{
+	"/open/tx/send": true,
+}
+
+//HTTPStatusError 节点返回了非200的HTTP状态码
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, string(e.Body))
+}
+
+//TransportPolicy HTTP请求的超时及重试策略
+type TransportPolicy struct {
+	Timeout     time.Duration //单次请求超时
+	MaxAttempts int           //最大尝试次数，包含第一次请求
+	Backoff     time.Duration //第一次重试前的等待时间，之后按指数增长
+	MaxBackoff  time.Duration //重试等待时间上限
+}
+
+//NewTransportPolicy 默认策略
+func NewTransportPolicy() *TransportPolicy {
+	return &TransportPolicy{
+		Timeout:     defaultRequestTimeout,
+		MaxAttempts: defaultRequestMaxAttempts,
+		Backoff:     defaultRequestBackoff,
+		MaxBackoff:  defaultRequestMaxBackoff,
+	}
+}
+
+//forPath 接口使用的策略，非幂等的接口只请求一次
+func (p *TransportPolicy) forPath(path string) *TransportPolicy {
+	if !nonIdempotentPaths[path] {
+		return p
+	}
+	copied := *p
+	copied.MaxAttempts = 1
+	return &copied
+}
+
+//backoff 第attempt次重试前的等待时间，指数增长并加入随机抖动
+func (p *TransportPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d = d * 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	//等待时间在[d/2, d]之间随机
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+//post 按策略发送POST请求，网络错误、5xx及429响应会退避重试，返回响应内容
+func (p *TransportPolicy) post(r *req.Req, url string, v ...interface{}) ([]byte, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			wait := p.backoff(attempt - 1)
+			log.Std.Warning("request %s failed, retry %d/%d after %v; last error: %v", url, attempt-1, maxAttempts-1, wait, lastErr)
+			time.Sleep(wait)
+		}
+
+		body, retry, err := p.postOnce(r, url, v...)
+		if err == nil {
+			return body, nil
+		}
+
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+//postOnce 发送一次请求，返回是否可以重试
+func (p *TransportPolicy) postOnce(r *req.Req, url string, v ...interface{}) ([]byte, bool, error) {
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	params := append(append([]interface{}{}, v...), ctx)
+	resp, err := r.Post(url, params...)
+	if err != nil {
+		return nil, true, err
+	}
+
+	body, err := resp.ToBytes()
+	if err != nil {
+		return nil, true, err
+	}
+
+	statusCode := resp.Response().StatusCode
+	if statusCode != http.StatusOK {
+		return nil, isRetryableStatus(statusCode), &HTTPStatusError{StatusCode: statusCode, Body: body}
+	}
+
+	return body, false, nil
+}
+
+//isRetryableStatus 5xx及429响应可以重试
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,95 @@
+package xbt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+func testFastPolicy() *TransportPolicy {
+	return &TransportPolicy{
+		Timeout:     200 * time.Millisecond,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+	}
+}
+
+func TestTransportPolicy_Retry_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+	srv.AddBlocks(5)
+
+	c := NewClient(srv.URL, false, symbol, currencyDecimal)
+	c.Policy = testFastPolicy()
+
+	//5xx和超时会重试
+	srv.InjectFault(xbtmock.PathBlockHeight, xbtmock.Fault{HTTPStatus: http.StatusBadGateway, Times: 1})
+	srv.InjectFault(xbtmock.PathBlockHeight, xbtmock.Fault{Delay: 500 * time.Millisecond, Times: 1})
+
+	height, err := c.getBlockHeight()
+	if err != nil || height != 5 {
+		t.Fatalf("getBlockHeight = %d, %v; want 5", height, err)
+	}
+	if n := srv.Requests(xbtmock.PathBlockHeight); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+
+	//4xx不重试
+	srv.InjectFault(xbtmock.PathBlockHeight, xbtmock.Fault{HTTPStatus: http.StatusBadRequest, Times: 1})
+	if _, err := c.getBlockHeight(); err == nil {
+		t.Errorf("expected error for http 400")
+	}
+	if n := srv.Requests(xbtmock.PathBlockHeight); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+
+	//超过最大尝试次数
+	srv.InjectFault(xbtmock.PathBlockHeight, xbtmock.Fault{HTTPStatus: http.StatusServiceUnavailable, Times: 3})
+	if _, err := c.getBlockHeight(); err == nil {
+		t.Errorf("expected error after max attempts")
+	}
+}
+
+func TestTransportPolicy_Backoff(t *testing.T) {
+	p := &TransportPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		d := p.backoff(attempt)
+		if d < max/2 || d > max {
+			t.Errorf("backoff(%d) = %v, want in [%v, %v]", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestTransportPolicy_SendNoRetry_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	c := NewClient(srv.URL, false, symbol, currencyDecimal)
+	c.Policy = testFastPolicy()
+
+	//广播交易不重试
+	ts, _ := testSignedTx(t, testDepositAddress, "0.5")
+	srv.InjectFault(xbtmock.PathTxSend, xbtmock.Fault{HTTPStatus: http.StatusBadGateway, Times: 1})
+	if _, err := c.sendTransaction(ts); err == nil {
+		t.Fatal("expected error for http 502")
+	}
+	if n := srv.Requests(xbtmock.PathTxSend); n != 1 {
+		t.Errorf("send requests = %d, want 1", n)
+	}
+
+	//重复广播已被节点接受的交易，节点返回nonce重复，交易池中有该交易时视为成功
+	ts, _ = testSignedTx(t, testDepositAddress, "0.6")
+	if _, err := c.sendTransaction(ts); err != nil {
+		t.Fatalf("sendTransaction failed: %v", err)
+	}
+	txid, err := c.sendTransaction(ts)
+	if err != nil || txid != ts.Hash {
+		t.Fatalf("sendTransaction = %s, %v; want %s", txid, err, ts.Hash)
+	}
+	if len(srv.Submitted()) != 1 {
+		t.Errorf("submitted = %d, want 1", len(srv.Submitted()))
+	}
+}
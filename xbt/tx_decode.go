@@ -22,23 +22,41 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/xbt-adapter/xbtTransaction"
 	"github.com/shopspring/decimal"
+	"math/big"
 	"sort"
-	"strconv"
 	"time"
 
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
 
+//TxVerifyError 交易单校验失败，Cause为xbtTransaction中的具体原因，如ErrTxHashMismatch、ErrSignatureVerifyFailed、ErrSenderMismatch
+type TxVerifyError struct {
+	Cause error
+}
+
+func (e *TxVerifyError) Error() string {
+	return "transaction verify failed: " + e.Cause.Error()
+}
+
+func (e *TxVerifyError) Unwrap() error { return e.Cause }
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *TxVerifyError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "%s", e.Error())
+}
+
 type TransactionDecoder struct {
 	openwallet.TransactionDecoderBase
 	openwallet.AddressDecoderV2
-	wm *WalletManager //钱包管理者
+	wm  *WalletManager   //钱包管理者
+	now func() time.Time //当前时间，用于检查交易有效期
 }
 
 //NewTransactionDecoder 交易单解析器
 func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 	decoder := TransactionDecoder{}
 	decoder.wm = wm
+	decoder.now = time.Now
 	return &decoder
 }
 
@@ -76,15 +94,35 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		return nil, err
 	}
 
+	//过期的交易需要用RebuildExpiredRawTransaction重建后重新签名
+	if err := decoder.checkTxExpiry(txStruct); err != nil {
+		return nil, ToOpenwalletError(err, openwallet.ErrSubmitRawTransactionFailed)
+	}
+
 	txid, err := decoder.wm.ApiClient.sendTransaction( txStruct )
 	if err != nil {
 		decoder.wm.Log.Error("Error Tx to send: ", rawTx.RawHex)
-		return nil, err
+		return nil, ToOpenwalletError(err, openwallet.ErrSubmitRawTransactionFailed)
 	}
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
+	//跟踪交易的确认状态
+	if txStruct.Amount != nil && txStruct.Fee != nil {
+		decoder.wm.TxTracker.TrackTransaction(&Transaction{
+			TxID:      txid,
+			From:      from,
+			To:        txStruct.To,
+			Amount:    convertDecimalToBigInt(*txStruct.Amount, decoder.wm.Decimal()),
+			Fee:       convertDecimalToBigInt(*txStruct.Fee, decoder.wm.Decimal()),
+			TimeStamp: normalizeTimestamp(txStruct.Time),
+			Status:    "1",
+		})
+	} else {
+		decoder.wm.TxTracker.Track(txid)
+	}
+
 	decimals := int32(6)
 
 	tx := openwallet.Transaction{
@@ -106,98 +144,43 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 
 func (decoder *TransactionDecoder) CreateXbtRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
-	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID)
+	//一笔XBT交易只能有一个接收地址，多个接收地址使用CreateBatchRawTransactionWithError
+	if len(rawTx.To) != 1 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "XBT transaction must have exactly one receiver, got %d, use CreateBatchRawTransactionWithError for multiple receivers", len(rawTx.To))
+	}
 
+	addressesBalanceList, err := decoder.getAccountBalances(wrapper, rawTx.Account.AccountID)
 	if err != nil {
 		return err
 	}
 
-	if len(addresses) == 0 {
-		return openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", rawTx.Account.AccountID)
+	return decoder.createTransferFromBalances(wrapper, rawTx, addressesBalanceList)
+}
+
+//getAccountBalances 查询账户下所有地址的余额
+func (decoder *TransactionDecoder) getAccountBalances(wrapper openwallet.WalletDAI, accountID string) ([]*AddrBalance, error) {
+
+	addresses, err := wrapper.GetAddressList(0, -1, "AccountID", accountID)
+	if err != nil {
+		return nil, err
 	}
 
-	addressesBalanceList := make([]AddrBalance, 0, len(addresses))
+	if len(addresses) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", accountID)
+	}
 
+	addressesBalanceList := make([]*AddrBalance, 0, len(addresses))
 	for i, addr := range addresses {
 		balance, err := decoder.wm.ApiClient.getBalance(addr.Address)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		balance.index = i
-		addressesBalanceList = append(addressesBalanceList, *balance)
-	}
-
-	sort.Slice(addressesBalanceList, func(i int, j int) bool {
-		return addressesBalanceList[i].Balance.Cmp(addressesBalanceList[j].Balance) >= 0
-	})
-
-	var amountStr, to string
-	for k, v := range rawTx.To {
-		to = k
-		amountStr = v
-		break
+		addressesBalanceList = append(addressesBalanceList, balance)
 	}
 
-	amount, err := decimal.NewFromString( amountStr )
-	if err!=nil {
-		return errors.New( "wrong amount : " + amountStr )
-	}
-
-	fee, err := decoder.GetTxFee(rawTx.FeeRate, &amount)
-	if err!=nil {
-		return err
-	}
-
-	from := ""
-	nonce := uint64(0)
-	for _, a := range addressesBalanceList {
-		from = a.Address
-		break
-	}
-
-	if from == "" {
-		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance: %s is not enough", amountStr)
-	}
-
-	rawTx.TxFrom = []string{from}
-	rawTx.TxTo = []string{to}
-	rawTx.TxAmount = amountStr
-	rawTx.Fees = fee.String()
-	rawTx.FeeRate = fee.String()
-
-	emptyTrans, message, err := decoder.CreateEmptyRawTransactionAndMessage(to, &amount, &fee)
-	if err != nil {
-		return err
-	}
-	rawTx.RawHex = emptyTrans
-
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
-	}
-
-	keySigs := make([]*openwallet.KeySignature, 0)
-
-	addr, err := wrapper.GetAddress(from)
-	if err != nil {
-		return err
-	}
-	signature := openwallet.KeySignature{
-		EccType: decoder.wm.Config.CurveType,
-		Nonce:   "0x" + strconv.FormatUint(nonce, 16),
-		Address: addr,
-		Message: message,
-	}
-
-	keySigs = append(keySigs, &signature)
-
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
-
-	rawTx.FeeRate = fee.String()
-
-	rawTx.IsBuilt = true
-
-	return nil
+	return addressesBalanceList, nil
 }
 
 func (decoder *TransactionDecoder) SignXbtRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
@@ -237,6 +220,7 @@ func (decoder *TransactionDecoder) VerifyXBTRawTransaction(wrapper openwallet.Wa
 		emptyTrans = rawTx.RawHex
 		signature  = ""
 		pub = ""
+		from = ""
 	)
 	//
 	for accountID, keySignatures := range rawTx.Signatures {
@@ -245,33 +229,40 @@ func (decoder *TransactionDecoder) VerifyXBTRawTransaction(wrapper openwallet.Wa
 
 			signature = keySignature.Signature
 			pub = keySignature.Address.PublicKey
+			from = keySignature.Address.Address
 
 			log.Debug("Signature:", keySignature.Signature)
 			log.Debug("PublicKey:", keySignature.Address.PublicKey)
 		}
 	}
 
+	rawTx.IsCompleted = false
+
+	if len(signature) == 0 {
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "transaction signature is empty")
+	}
+
 	pubkey, err := hex.DecodeString(pub)
 	if err!=nil {
-		return errors.New("wrong public key")
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "wrong public key : %s", pub)
 	}
 
-	signedTrans, pass := xbtTransaction.VerifyAndCombineTransaction( emptyTrans, signature, pubkey)
-
-	if pass {
-		log.Debug("transaction verify passed")
-		rawTx.IsCompleted = true
-		rawTx.RawHex = signedTrans
-	} else {
+	signedTrans, err := xbtTransaction.VerifyAndCombineTransaction( emptyTrans, signature, pubkey, from)
+	if err != nil {
 		log.Debug("transaction verify failed")
-		rawTx.IsCompleted = false
+		return &TxVerifyError{Cause: err}
 	}
 
+	log.Debug("transaction verify passed")
+	rawTx.IsCompleted = true
+	rawTx.RawHex = signedTrans
+
 	return nil
 }
 
+//GetRawTransactionFeeRate 返回当前生效的手续费规则，如：percentage 0.2% min 0.1
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	return decoder.wm.Config.FixedFee, "TX", nil
+	return decoder.wm.FeeSchedule.String(), "TX", nil
 }
 
 //CreateSummaryRawTransaction 创建汇总交易，返回原始交易单数组
@@ -397,64 +388,195 @@ func (decoder *TransactionDecoder) createRawTransaction(wrapper openwallet.Walle
 	}
 
 	fee, err := decoder.GetTxFee( rawTx.FeeRate, &amount )
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	//使用链上的余额及已使用的nonce
+	sender, err := decoder.wm.ApiClient.getBalance(addrBalance.Address)
+	if err != nil {
+		return err
+	}
+
+	return decoder.buildRawTransaction(wrapper, rawTx, sender, to, amount, fee)
+}
+
+//CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
+func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+	raTxWithErr := make([]*openwallet.RawTransactionWithError, 0)
+	rawTxs, err := decoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range rawTxs {
+		raTxWithErr = append(raTxWithErr, &openwallet.RawTransactionWithError{
+			RawTx: tx,
+			Error: nil,
+		})
+	}
+	return raTxWithErr, nil
+}
+
+//CreateBatchRawTransactionWithError 批量转账，rawTx.To的每个接收地址生成一笔独立的交易单（各自的nonce和手续费），返回每笔的创建结果
+func (decoder *TransactionDecoder) CreateBatchRawTransactionWithError(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+
+	if len(rawTx.To) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "receiver addresses is empty")
+	}
 
-	from := addrBalance.Address
-	fromAddr, err := wrapper.GetAddress(from)
+	addressesBalanceList, err := decoder.getAccountBalances(wrapper, rawTx.Account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	//按地址排序，保证每次生成的顺序一致
+	receivers := make([]string, 0, len(rawTx.To))
+	for to := range rawTx.To {
+		receivers = append(receivers, to)
+	}
+	sort.Strings(receivers)
+
+	rawTxWithErrArray := make([]*openwallet.RawTransactionWithError, 0, len(receivers))
+	for _, to := range receivers {
+		transferTx := &openwallet.RawTransaction{
+			Coin:     rawTx.Coin,
+			Account:  rawTx.Account,
+			ExtParam: rawTx.ExtParam,
+			To: map[string]string{
+				to: rawTx.To[to],
+			},
+			Required: 1,
+			FeeRate:  rawTx.FeeRate,
+		}
+
+		createErr := decoder.createTransferFromBalances(wrapper, transferTx, addressesBalanceList)
+		if createErr != nil {
+			decoder.wm.Log.Std.Error("create transfer to %s failed; unexpected error: %v", to, createErr)
+		}
+
+		rawTxWithErrArray = append(rawTxWithErrArray, &openwallet.RawTransactionWithError{
+			RawTx: transferTx,
+			Error: openwallet.ConvertError(createErr),
+		})
+	}
+
+	return rawTxWithErrArray, nil
+}
+
+//createTransferFromBalances 从余额足够支付数量+手续费的地址创建交易单，并扣减该地址的可用余额
+func (decoder *TransactionDecoder) createTransferFromBalances(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, addressesBalanceList []*AddrBalance) error {
+
+	var amountStr, to string
+	for k, v := range rawTx.To {
+		to = k
+		amountStr = v
+		break
+	}
+
+	if !decoder.wm.Decoder.AddressVerify(to) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wrong receiver address : %s", to)
+	}
+
+	amount, err := decimal.NewFromString( amountStr )
+	if err!=nil || amount.Sign() <= 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wrong amount : %s", amountStr)
+	}
+
+	fee, err := decoder.GetTxFee(rawTx.FeeRate, &amount)
+	if err!=nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	need := convertDecimalToBigInt(amount.Add(fee), decoder.wm.Decimal())
+
+	sender := decoder.wm.SenderSelector.Select(addressesBalanceList, need)
+	if sender == nil {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "the balance is not enough to send %s with fee %s to %s", amountStr, fee.String(), to)
+	}
+
+	err = decoder.buildRawTransaction(wrapper, rawTx, sender, to, amount, fee)
+	if err != nil {
+		return err
+	}
+
+	//扣减已分配的余额，避免同一批次重复使用
+	sender.Balance = new(big.Int).Sub(sender.Balance, need)
+	if sender.Free != nil {
+		sender.Free = new(big.Int).Sub(sender.Free, need)
+	}
+
+	return nil
+}
+
+//buildRawTransaction 检查发送地址的余额并分配nonce，生成待签名的交易单
+func (decoder *TransactionDecoder) buildRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, sender *AddrBalance, to string, amount, fee decimal.Decimal) error {
+
+	from := sender.Address
+	addr, err := wrapper.GetAddress(from)
 	if err != nil {
 		return err
 	}
 
+	//发送地址的可用余额需要足够支付数量+手续费
+	need := convertDecimalToBigInt(amount.Add(fee), decoder.wm.Decimal())
+	if sender.spendable().Cmp(need) < 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAddress, "the balance of %s is not enough to send %s with fee %s", from, amount.String(), fee.String())
+	}
+
+	nonce, err := decoder.nextNonce(wrapper, from, sender.Nonce)
+	if err != nil {
+		return err
+	}
+
+	emptyTrans, message, err := decoder.CreateEmptyRawTransactionAndMessage(to, &amount, &fee, nonce)
+	if err != nil {
+		decoder.releaseNonce(wrapper, from, nonce)
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
 	rawTx.TxFrom = []string{from}
 	rawTx.TxTo = []string{to}
-	rawTx.TxAmount = amountStr
+	rawTx.TxAmount = amount.String()
 	rawTx.Fees = fee.String()
 	rawTx.FeeRate = fee.String()
-
-	emptyTrans, hash, err := decoder.CreateEmptyRawTransactionAndMessage(to, &amount, &fee)
-
 	rawTx.RawHex = emptyTrans
 
 	if rawTx.Signatures == nil {
 		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
 	}
 
-	keySigs := make([]*openwallet.KeySignature, 0)
-
 	signature := openwallet.KeySignature{
 		EccType: decoder.wm.Config.CurveType,
-		Address: fromAddr,
-		Message: hash,
+		Nonce:   "0x0",
+		Address: addr,
+		Message: message,
 	}
 
-	keySigs = append(keySigs, &signature)
-
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
-
-	rawTx.FeeRate = fee.String()
+	rawTx.Signatures[rawTx.Account.AccountID] = []*openwallet.KeySignature{&signature}
 
 	rawTx.IsBuilt = true
 
 	return nil
 }
 
-//CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
-func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
-	raTxWithErr := make([]*openwallet.RawTransactionWithError, 0)
-	rawTxs, err := decoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+//nextNonce 分配发送地址的nonce
+func (decoder *TransactionDecoder) nextNonce(wrapper openwallet.WalletDAI, address string, chainNonce uint64) (uint64, error) {
+	nonce, err := decoder.wm.NonceManager.NextNonce(wrapper, address, chainNonce)
 	if err != nil {
-		return nil, err
+		return 0, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
 	}
-	for _, tx := range rawTxs {
-		raTxWithErr = append(raTxWithErr, &openwallet.RawTransactionWithError{
-			RawTx: tx,
-			Error: nil,
-		})
+	return nonce, nil
+}
+
+//releaseNonce 交易单未生成时释放已分配的nonce，供下一笔交易使用
+func (decoder *TransactionDecoder) releaseNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
+	if err := decoder.wm.NonceManager.Release(wrapper, address, nonce); err != nil {
+		decoder.wm.Log.Std.Error("release nonce %d of address %s failed; unexpected error: %v", nonce, address, err)
 	}
-	return raTxWithErr, nil
 }
 
-func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(to string, amount, fee *decimal.Decimal) (string, string, error) {
-	txStruct, hash, err := xbtTransaction.GetTxStruct(to, amount, fee)
+func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(to string, amount, fee *decimal.Decimal, nonce uint64) (string, string, error) {
+	txStruct, hash, err := xbtTransaction.GetTxStructWithNonce(to, amount, fee, nonce)
 	if err != nil {
 		return "", "", err
 	}
@@ -465,30 +587,25 @@ func (decoder *TransactionDecoder) CreateEmptyRawTransactionAndMessage(to string
 	return txStruct.ToJSONString(), hex.EncodeToString(hash), nil
 }
 
-//通过转账金额，计算手续费，千分之2，最低0.1
+//GetTxFee 按手续费规则计算转账金额的手续费，指定feeRate时使用该手续费，同样限制在最低及最高手续费之间
 func (decoder *TransactionDecoder) GetTxFee(feeRate string, amount *decimal.Decimal) (decimal.Decimal, error) {
-	zeroFee := decimal.NewFromInt32(0)
-
-	//最低0.1
-	minFee, err := decimal.NewFromString( decoder.wm.Config.FixedFee )
-	if err != nil{
-		return zeroFee, errors.New("wrong minFee : " + decoder.wm.Config.FixedFee )
-	}
-	netFeeRate, _ := decimal.NewFromString("0.002")
-
-	//计算出来的手续费
-	fee := amount.Mul( netFeeRate ).Round( decoder.wm.Config.Decimal )
+	schedule := decoder.wm.FeeSchedule
 
 	if len(feeRate) > 0 {
-		result, err := decimal.NewFromString( feeRate )
-		if err != nil{
-			return zeroFee, errors.New("wrong feeRate : " + feeRate )
+		fee, err := decimal.NewFromString(feeRate)
+		if err != nil || fee.IsNegative() {
+			return decimal.Zero, errors.New("wrong feeRate : " + feeRate)
 		}
-		fee = result
+		return schedule.Clamp(fee), nil
 	}
 
-	if fee.Cmp( minFee )<0 {	//低于0.1，就按照0.1收
-		fee = minFee
+	if schedule.Mode == FeeModeNode {
+		fee, err := decoder.wm.ApiClient.getTxFee(schedule.Endpoint, *amount)
+		if err == nil {
+			return schedule.Clamp(fee), nil
+		}
+		decoder.wm.Log.Std.Warning("get fee from node failed, use fallback fee schedule; unexpected error: %v", err)
 	}
-	return fee, nil
-}
\ No newline at end of file
+
+	return schedule.Fee(*amount, decoder.wm.Config.Decimal)
+}
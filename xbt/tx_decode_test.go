@@ -0,0 +1,167 @@
+package xbt
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+)
+
+//testTxWalletDAI 账户只有一个发送地址的钱包
+type testTxWalletDAI struct {
+	*testNonceDAI
+	address *openwallet.Address
+}
+
+func (d *testTxWalletDAI) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	return []*openwallet.Address{d.address}, nil
+}
+
+func (d *testTxWalletDAI) GetAddress(address string) (*openwallet.Address, error) {
+	return d.address, nil
+}
+
+//testTxWalletManager 连接只返回固定余额的节点
+func testTxWalletManager(t *testing.T, balance string) *WalletManager {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":200,"data":{"balance":"` + balance + `"}}`))
+	}))
+	dir, err := ioutil.TempDir("", "xbt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	})
+
+	ini := "serverAPI = \"" + srv.URL + "\"\n" +
+		"fixedFee = \"0.1\"\n" +
+		"dataDir = \"" + filepath.Join(dir, "data") + "\""
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	return wm
+}
+
+func TestTransactionDecoder_CreateBatchRawTransactionWithError(t *testing.T) {
+	wm := testTxWalletManager(t, "5")
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	from := "xB0000000000000000000000000000000000000000"
+	first := "xB1CE3Ff24Bbe10dc457320D0BB3602d5C79F844a5"
+	second := "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B"
+	dai := &testTxWalletDAI{
+		testNonceDAI: newTestNonceDAI(),
+		address:      &openwallet.Address{AccountID: "testAccount", Address: from},
+	}
+
+	//第一笔扣减余额后，第二笔的余额不足
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: symbol},
+		Account: &openwallet.AssetsAccount{AccountID: "testAccount"},
+		To: map[string]string{
+			second: "3",
+			first:  "2",
+		},
+	}
+	results, err := decoder.CreateBatchRawTransactionWithError(dai, rawTx)
+	if err != nil {
+		t.Fatalf("CreateBatchRawTransactionWithError failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %d, want 2", len(results))
+	}
+
+	//按接收地址排序，每个接收地址一笔交易单
+	if r := results[0]; r.Error != nil || !r.RawTx.IsBuilt || r.RawTx.TxTo[0] != first ||
+		r.RawTx.TxFrom[0] != from || r.RawTx.TxAmount != "2" || r.RawTx.Fees != "0.1" {
+		t.Errorf("unexpected first result: %+v, %v", r.RawTx, r.Error)
+	}
+	if r := results[1]; r.Error == nil || r.Error.Code() != openwallet.ErrInsufficientBalanceOfAccount ||
+		r.RawTx.IsBuilt || r.RawTx.To[second] != "3" {
+		t.Errorf("unexpected second result: %+v, %v", r.RawTx, r.Error)
+	}
+
+	//失败的交易单不占用nonce
+	if nonce, _ := wm.NonceManager.NextNonce(dai, from, 0); nonce != 2 {
+		t.Errorf("next nonce = %d, want 2", nonce)
+	}
+
+	//没有接收地址
+	rawTx.To = map[string]string{}
+	if _, err := decoder.CreateBatchRawTransactionWithError(dai, rawTx); err == nil {
+		t.Error("expected error for empty receivers")
+	}
+}
+
+func TestTransactionDecoder_VerifyRawTransaction_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	wm := testMockWalletManager(t, srv)
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+
+	//签名后用modify修改交易单再校验
+	verify := func(modify func(rawTx *openwallet.RawTransaction)) (*openwallet.RawTransaction, error) {
+		rawTx := testExpiryRawTx(t, time.Now())
+		for _, keySignature := range rawTx.Signatures[testAccountID] {
+			signature, _ := xbtTransaction.SignTransaction(keySignature.Message, testExpiryPrikey)
+			keySignature.Signature = hex.EncodeToString(signature)
+		}
+		modify(rawTx)
+		return rawTx, decoder.VerifyRawTransaction(nil, rawTx)
+	}
+
+	if rawTx, err := verify(func(*openwallet.RawTransaction) {}); err != nil || !rawTx.IsCompleted {
+		t.Fatalf("VerifyRawTransaction failed: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(rawTx *openwallet.RawTransaction)
+		cause  error
+	}{
+		{"signature", func(rawTx *openwallet.RawTransaction) {
+			keySignature := rawTx.Signatures[testAccountID][0]
+			sig, _ := hex.DecodeString(keySignature.Signature)
+			sig[10] ^= 0xff
+			keySignature.Signature = hex.EncodeToString(sig)
+		}, xbtTransaction.ErrSignatureVerifyFailed},
+		{"hash", func(rawTx *openwallet.RawTransaction) {
+			ts, _ := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+			ts.Hash = strings.Repeat("0", len(ts.Hash))
+			rawTx.RawHex = ts.ToJSONString()
+		}, xbtTransaction.ErrTxHashMismatch},
+	}
+	for _, c := range cases {
+		rawTx, err := verify(c.modify)
+		var verifyErr *TxVerifyError
+		if !errors.As(err, &verifyErr) || !errors.Is(err, c.cause) {
+			t.Errorf("%s: err = %v, want cause %v", c.name, err, c.cause)
+		}
+		if rawTx.IsCompleted {
+			t.Errorf("%s: IsCompleted should be false", c.name)
+		}
+		if code := ToOpenwalletError(err, openwallet.ErrUnknownException).Code(); code != openwallet.ErrVerifyRawTransactionFailed {
+			t.Errorf("%s: openwallet code = %d", c.name, code)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+)
+
+const defaultTxValidity = 30 * time.Minute
+
+//TxExpiredError 交易的time超出有效期
+type TxExpiredError struct {
+	TxID     string
+	Age      time.Duration
+	Validity time.Duration
+}
+
+func (e *TxExpiredError) Error() string {
+	return fmt.Sprintf("transaction %s expired, created %s ago, validity %s", e.TxID, e.Age, e.Validity)
+}
+
+//OpenwalletError 转换为openwallet的错误码
+func (e *TxExpiredError) OpenwalletError() *openwallet.Error {
+	return openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "%s", e.Error())
+}
+
+//TxRebuildLink 过期交易重建的记录，旧交易与新交易使用相同的nonce，最多只有一笔能上链
+type TxRebuildLink struct {
+	OldTxID     string `json:"oldTxID"`
+	NewTxID     string `json:"newTxID"`
+	To          string `json:"to"`
+	Nonce       uint64 `json:"nonce"`
+	OldTime     uint64 `json:"oldTime"`
+	NewTime     uint64 `json:"newTime"`
+	RebuildTime int64  `json:"rebuildTime"`
+}
+
+//checkTxExpiry 交易time距今超过有效期时返回TxExpiredError，有效期为0时不检查
+func (decoder *TransactionDecoder) checkTxExpiry(ts *xbtTransaction.TxStruct) error {
+	validity := decoder.wm.Config.TxValidity
+	if validity <= 0 {
+		return nil
+	}
+
+	age := decoder.now().Sub(time.Unix(0, int64(ts.Time)*int64(time.Millisecond)))
+	if age > validity {
+		return &TxExpiredError{TxID: ts.Hash, Age: age.Truncate(time.Second), Validity: validity}
+	}
+	return nil
+}
+
+//IsRawTransactionExpired 交易单是否已超出有效期
+func (decoder *TransactionDecoder) IsRawTransactionExpired(rawTx *openwallet.RawTransaction) (bool, error) {
+	ts, err := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+	if err != nil {
+		return false, err
+	}
+	return decoder.checkTxExpiry(ts) != nil, nil
+}
+
+//RebuildExpiredRawTransaction 以当前时间重建过期的交易单，保留原来的nonce，清除签名等待重新签名
+func (decoder *TransactionDecoder) RebuildExpiredRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	ts, err := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "wrong raw transaction: %v", err)
+	}
+
+	if decoder.checkTxExpiry(ts) == nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction %s is not expired", ts.Hash)
+	}
+
+	if err := decoder.checkTxNotOnChain(rawTx, ts); err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	builder := &xbtTransaction.TxBuilder{
+		Clock: func() uint64 { return uint64(decoder.now().UnixNano() / 1e6) },
+	}
+	built, err := builder.BuildWithNonce(ts.To, ts.Amount, ts.Fee, ts.Nonce)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+
+	link := &TxRebuildLink{
+		OldTxID:     ts.Hash,
+		NewTxID:     built.Tx.Hash,
+		To:          ts.To,
+		Nonce:       ts.Nonce,
+		OldTime:     ts.Time,
+		NewTime:     built.Tx.Time,
+		RebuildTime: decoder.now().Unix(),
+	}
+	if err := decoder.wm.recordTxRebuild(link); err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "save rebuild link failed: %v", err)
+	}
+	decoder.wm.TxTracker.Replace(link.OldTxID, link.NewTxID)
+
+	rawTx.RawHex = built.Tx.ToJSONString()
+	rawTx.TxID = ""
+	rawTx.IsCompleted = false
+	rawTx.IsSubmit = false
+	for _, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			keySignature.Message = hex.EncodeToString(built.MessageHash)
+			keySignature.Signature = ""
+		}
+	}
+
+	decoder.wm.Log.Std.Info("expired transaction %s rebuilt as %s, nonce: %d", link.OldTxID, link.NewTxID, link.Nonce)
+
+	return nil
+}
+
+//checkTxNotOnChain 重建前向节点确认原交易不在交易池中且未上链，无法确认时不重建，避免同一笔转账的两个版本都上链
+func (decoder *TransactionDecoder) checkTxNotOnChain(rawTx *openwallet.RawTransaction, ts *xbtTransaction.TxStruct) error {
+	pending, err := decoder.wm.ApiClient.txPending(ts.Hash)
+	if err != nil {
+		return fmt.Errorf("check pending transaction %s failed: %v", ts.Hash, err)
+	}
+	if pending {
+		return fmt.Errorf("transaction %s is still pending in node", ts.Hash)
+	}
+
+	tracked, tracking := decoder.wm.TxTracker.GetTx(ts.Hash)
+	if tracking && tracked.Status == TxStatusConfirmed {
+		return fmt.Errorf("transaction %s is already confirmed", ts.Hash)
+	}
+
+	//随机nonce无法根据链上nonce判断，只有扫描器确认超时未上链的交易才能重建
+	if decoder.wm.NonceManager.Strategy == NonceStrategyRandom {
+		if !tracking || tracked.Status != TxStatusDropped {
+			return fmt.Errorf("transaction %s is not known to be dropped", ts.Hash)
+		}
+		return nil
+	}
+
+	//链上已使用该nonce，原交易或相同nonce的交易已上链
+	var from string
+	for _, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			if keySignature.Address != nil {
+				from = keySignature.Address.Address
+			}
+		}
+	}
+	if len(from) == 0 {
+		return fmt.Errorf("sender of transaction %s is unknown", ts.Hash)
+	}
+	balance, err := decoder.wm.ApiClient.getBalance(from)
+	if err != nil {
+		return fmt.Errorf("get nonce of %s failed: %v", from, err)
+	}
+	if balance.Nonce >= ts.Nonce {
+		return fmt.Errorf("nonce %d of %s is already used on chain", ts.Nonce, from)
+	}
+	return nil
+}
+
+//ResubmitExpiredRawTransaction 重建过期的交易单，用钱包密钥重新签名后广播
+func (decoder *TransactionDecoder) ResubmitExpiredRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
+	if err := decoder.RebuildExpiredRawTransaction(wrapper, rawTx); err != nil {
+		return nil, err
+	}
+	if err := decoder.SignRawTransaction(wrapper, rawTx); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "%v", err)
+	}
+	if err := decoder.VerifyRawTransaction(wrapper, rawTx); err != nil {
+		return nil, err
+	}
+	return decoder.SubmitRawTransaction(wrapper, rawTx)
+}
+
+//txRebuildLinksFile 重建记录保存在数据目录
+func (wm *WalletManager) txRebuildLinksFile() string {
+	return filepath.Join(wm.Config.dbPath, "tx_rebuild_links.json")
+}
+
+//GetTxRebuildLinks 查询所有过期交易的重建记录
+func (wm *WalletManager) GetTxRebuildLinks() ([]*TxRebuildLink, error) {
+	wm.rebuildMu.Lock()
+	defer wm.rebuildMu.Unlock()
+
+	return wm.loadTxRebuildLinks()
+}
+
+//GetTxReplacement 查询交易重建后最新的交易hash，没有重建过时返回false
+func (wm *WalletManager) GetTxReplacement(txid string) (string, bool) {
+	links, err := wm.GetTxRebuildLinks()
+	if err != nil {
+		return "", false
+	}
+
+	next := make(map[string]string, len(links))
+	for _, l := range links {
+		next[l.OldTxID] = l.NewTxID
+	}
+
+	latest, found := txid, false
+	for i := 0; i < len(links); i++ {
+		n, ok := next[latest]
+		if !ok {
+			break
+		}
+		latest, found = n, true
+	}
+	return latest, found
+}
+
+func (wm *WalletManager) loadTxRebuildLinks() ([]*TxRebuildLink, error) {
+	data, err := ioutil.ReadFile(wm.txRebuildLinksFile())
+	if os.IsNotExist(err) {
+		return []*TxRebuildLink{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	links := make([]*TxRebuildLink, 0)
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+//recordTxRebuild 追加重建记录，先写临时文件再替换
+func (wm *WalletManager) recordTxRebuild(link *TxRebuildLink) error {
+	wm.rebuildMu.Lock()
+	defer wm.rebuildMu.Unlock()
+
+	links, err := wm.loadTxRebuildLinks()
+	if err != nil {
+		return err
+	}
+	links = append(links, link)
+
+	data, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+
+	file := wm.txRebuildLinksFile()
+	if err := ioutil.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
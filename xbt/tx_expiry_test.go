@@ -0,0 +1,177 @@
+package xbt
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+	"github.com/shopspring/decimal"
+)
+
+var testExpiryPrikey, _ = hex.DecodeString("4f3edf983ac636a65a842ce7c78d9aa706d3b113bce9c46f30d7d21715b23b1d")
+
+//testExpiryRawTx 以指定时间创建交易单
+func testExpiryRawTx(t *testing.T, created time.Time) *openwallet.RawTransaction {
+	pubkey, _ := owcrypt.GenPubkey(testExpiryPrikey, owcrypt.ECC_CURVE_SECP256K1)
+	from, _ := xbtTransaction.GetAddressByPublicKey(pubkey)
+
+	amount, _ := decimal.NewFromString("0.5")
+	fee, _ := decimal.NewFromString("0.1")
+	builder := &xbtTransaction.TxBuilder{Clock: xbtTransaction.FixedClock(uint64(created.UnixNano() / 1e6))}
+	built, err := builder.BuildWithNonce(testDepositAddress, &amount, &fee, 7)
+	if err != nil {
+		t.Fatalf("BuildWithNonce failed: %v", err)
+	}
+
+	return &openwallet.RawTransaction{
+		Account: &openwallet.AssetsAccount{AccountID: testAccountID},
+		RawHex:  built.Tx.ToJSONString(),
+		Signatures: map[string][]*openwallet.KeySignature{
+			testAccountID: {{
+				EccType: CurveType,
+				Address: &openwallet.Address{Address: from, PublicKey: hex.EncodeToString(pubkey)},
+				Message: hex.EncodeToString(built.MessageHash),
+			}},
+		},
+		IsBuilt: true,
+	}
+}
+
+//testSignExpiryRawTx 用测试私钥签名并验证交易单
+func testSignExpiryRawTx(t *testing.T, decoder *TransactionDecoder, rawTx *openwallet.RawTransaction) {
+	for _, keySignature := range rawTx.Signatures[testAccountID] {
+		signature, err := xbtTransaction.SignTransaction(keySignature.Message, testExpiryPrikey)
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		keySignature.Signature = hex.EncodeToString(signature)
+	}
+	if err := decoder.VerifyRawTransaction(nil, rawTx); err != nil {
+		t.Fatalf("VerifyRawTransaction failed: %v", err)
+	}
+}
+
+func TestTransactionDecoder_TxExpiry_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	wm := testMockWalletManager(t, srv, `txValidity = "10m"`)
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+	now := time.Now()
+	decoder.now = func() time.Time { return now }
+
+	//有效期内的交易不能重建
+	fresh := testExpiryRawTx(t, now.Add(-time.Minute))
+	if err := decoder.RebuildExpiredRawTransaction(nil, fresh); err == nil {
+		t.Fatal("rebuild of fresh transaction should fail")
+	}
+
+	rawTx := testExpiryRawTx(t, now.Add(-time.Hour))
+	testSignExpiryRawTx(t, decoder, rawTx)
+	oldTs, _ := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+	wm.TxTracker.Track(oldTs.Hash)
+
+	//过期交易拒绝广播
+	_, err := decoder.SubmitRawTransaction(nil, rawTx)
+	owErr, ok := err.(*openwallet.Error)
+	if !ok || owErr.Code() != openwallet.ErrSubmitRawTransactionFailed {
+		t.Fatalf("expected expired error, got %v", err)
+	}
+	if len(srv.Submitted()) != 0 {
+		t.Fatal("expired transaction should not be sent to node")
+	}
+	if expired, _ := decoder.IsRawTransactionExpired(rawTx); !expired {
+		t.Fatal("IsRawTransactionExpired = false")
+	}
+
+	if err := decoder.RebuildExpiredRawTransaction(nil, rawTx); err != nil {
+		t.Fatalf("RebuildExpiredRawTransaction failed: %v", err)
+	}
+	newTs, _ := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+	if newTs.Nonce != oldTs.Nonce || newTs.Time != uint64(now.UnixNano()/1e6) || newTs.Hash == oldTs.Hash {
+		t.Fatalf("unexpected rebuilt transaction: %s", rawTx.RawHex)
+	}
+	if rawTx.IsCompleted || rawTx.Signatures[testAccountID][0].Signature != "" {
+		t.Fatal("signature should be cleared after rebuild")
+	}
+
+	//记录旧交易到新交易的关联
+	if latest, found := wm.GetTxReplacement(oldTs.Hash); !found || latest != newTs.Hash {
+		t.Fatalf("GetTxReplacement = %s, %v", latest, found)
+	}
+	if tracked, _ := wm.TxTracker.GetTx(oldTs.Hash); tracked.Status != TxStatusReplaced || tracked.ReplacedBy != newTs.Hash {
+		t.Fatalf("unexpected tracked status: %+v", tracked)
+	}
+
+	//再次过期后重建，关联链指向最新的交易
+	now = now.Add(time.Hour)
+	if err := decoder.RebuildExpiredRawTransaction(nil, rawTx); err != nil {
+		t.Fatalf("RebuildExpiredRawTransaction failed: %v", err)
+	}
+	lastTs, _ := xbtTransaction.NewTxStructFromJSON(rawTx.RawHex)
+	if latest, _ := wm.GetTxReplacement(oldTs.Hash); latest != lastTs.Hash {
+		t.Fatalf("GetTxReplacement = %s, want %s", latest, lastTs.Hash)
+	}
+	links, _ := wm.GetTxRebuildLinks()
+	if len(links) != 2 || links[1].OldTxID != newTs.Hash {
+		t.Fatalf("unexpected rebuild links: %d", len(links))
+	}
+
+	testSignExpiryRawTx(t, decoder, rawTx)
+	tx, err := decoder.SubmitRawTransaction(nil, rawTx)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed: %v", err)
+	}
+	if tx.TxID != lastTs.Hash || len(srv.Submitted()) != 1 {
+		t.Fatalf("unexpected submit result: %s", tx.TxID)
+	}
+}
+
+func TestTransactionDecoder_RebuildOnChain_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	wm := testMockWalletManager(t, srv, `txValidity = "10m"`)
+	wm.ApiClient.Policy = testFastPolicy()
+	decoder := wm.TxDecoder.(*TransactionDecoder)
+	now := time.Now()
+	decoder.now = func() time.Time { return now }
+
+	//广播后过期，原交易仍在节点交易池中
+	rawTx := testExpiryRawTx(t, now)
+	testSignExpiryRawTx(t, decoder, rawTx)
+	if _, err := decoder.SubmitRawTransaction(nil, rawTx); err != nil {
+		t.Fatalf("SubmitRawTransaction failed: %v", err)
+	}
+	oldHex := rawTx.RawHex
+	now = now.Add(time.Hour)
+
+	if err := decoder.RebuildExpiredRawTransaction(nil, rawTx); err == nil || !strings.Contains(err.Error(), "pending") {
+		t.Fatalf("rebuild of pending transaction should fail, got %v", err)
+	}
+	if rawTx.RawHex != oldHex {
+		t.Fatal("raw transaction should not be changed")
+	}
+
+	//查询交易池失败时不重建
+	srv.InjectFault(xbtmock.PathTxPending, xbtmock.Fault{HTTPStatus: http.StatusServiceUnavailable})
+	if err := decoder.RebuildExpiredRawTransaction(nil, rawTx); err == nil {
+		t.Fatal("rebuild should fail when pending transactions are unavailable")
+	}
+	srv.ClearFaults()
+
+	//已上链的交易不能重建
+	srv.MineSubmitted()
+	if err := decoder.RebuildExpiredRawTransaction(nil, rawTx); err == nil {
+		t.Fatal("rebuild of mined transaction should fail")
+	}
+	if links, _ := wm.GetTxRebuildLinks(); len(links) != 0 {
+		t.Fatalf("unexpected rebuild links: %d", len(links))
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TxStatusPending   = "pending"   //已广播，未上链
+	TxStatusConfirmed = "confirmed" //已上链
+	TxStatusDropped   = "dropped"   //超时未上链
+	TxStatusReplaced  = "replaced"  //过期后已重建为新交易
+
+	defaultTxDropTimeout = 30 * time.Minute //默认超时时间
+	defaultTxRetention   = 24 * time.Hour   //默认已结束交易的保留时间
+)
+
+//TrackedTx 已广播交易的确认状态
+type TrackedTx struct {
+	TxID        string
+	SubmitTime  time.Time
+	Status      string
+	BlockHeight uint64
+	BlockHash   string
+	Transaction *Transaction //广播时的交易内容，用于节点不支持交易池查询时提取待确认交易
+	ReplacedBy  string       //重建后的交易hash
+	UpdateTime  time.Time    //最后一次状态变化的时间
+}
+
+//TxConfirmationObserver 交易确认状态变化的观测者
+type TxConfirmationObserver interface {
+	TxStatusNotify(tx *TrackedTx)
+}
+
+//TxTracker 跟踪SubmitRawTransaction广播的交易，根据扫描到的区块更新确认状态
+type TxTracker struct {
+	DropTimeout time.Duration //超过该时间未上链视为丢弃
+	Retention   time.Duration //已确认、已丢弃及已替换的交易保留该时间后不再跟踪，0为不清除
+
+	mu        sync.RWMutex
+	txs       map[string]*TrackedTx
+	observers map[TxConfirmationObserver]bool
+	now       func() time.Time
+}
+
+//NewTxTracker 创建交易确认跟踪器
+func NewTxTracker(dropTimeout time.Duration) *TxTracker {
+	if dropTimeout <= 0 {
+		dropTimeout = defaultTxDropTimeout
+	}
+
+	return &TxTracker{
+		DropTimeout: dropTimeout,
+		Retention:   defaultTxRetention,
+		txs:         make(map[string]*TrackedTx),
+		observers:   make(map[TxConfirmationObserver]bool),
+		now:         time.Now,
+	}
+}
+
+//AddObserver 添加观测者
+func (t *TxTracker) AddObserver(obj TxConfirmationObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observers[obj] = true
+}
+
+//RemoveObserver 移除观测者
+func (t *TxTracker) RemoveObserver(obj TxConfirmationObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.observers, obj)
+}
+
+//Track 记录已广播的交易，已记录的交易不重复记录
+func (t *TxTracker) Track(txid string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exist := t.txs[txid]; exist {
+		return
+	}
+
+	t.txs[txid] = &TrackedTx{
+		TxID:       txid,
+		SubmitTime: t.now(),
+		Status:     TxStatusPending,
+		UpdateTime: t.now(),
+	}
+}
+
+//TrackTransaction 记录已广播的交易及其内容
+func (t *TxTracker) TrackTransaction(tx *Transaction) {
+	t.Track(tx.TxID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	copied := *tx
+	t.txs[tx.TxID].Transaction = &copied
+}
+
+//PendingTransactions 已广播未上链且记录了交易内容的交易
+func (t *TxTracker) PendingTransactions() []Transaction {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]Transaction, 0)
+	for _, tx := range t.txs {
+		if tx.Status == TxStatusPending && tx.Transaction != nil {
+			result = append(result, *tx.Transaction)
+		}
+	}
+	return result
+}
+
+//GetTx 查询交易的确认状态
+func (t *TxTracker) GetTx(txid string) (*TrackedTx, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tx, ok := t.txs[txid]
+	if !ok {
+		return nil, false
+	}
+	copied := *tx
+	return &copied, true
+}
+
+//GetTxsByStatus 查询指定状态的交易
+func (t *TxTracker) GetTxsByStatus(status string) []*TrackedTx {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]*TrackedTx, 0)
+	for _, tx := range t.txs {
+		if tx.Status == status {
+			copied := *tx
+			result = append(result, &copied)
+		}
+	}
+	return result
+}
+
+//Remove 不再跟踪指定交易
+func (t *TxTracker) Remove(txid string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.txs, txid)
+}
+
+//ProcessBlock 区块中包含的已跟踪交易标记为已确认
+func (t *TxTracker) ProcessBlock(block *Block) {
+	changed := make([]*TrackedTx, 0)
+
+	t.mu.Lock()
+	for _, trx := range block.Transactions {
+		tx, ok := t.txs[trx.TxID]
+		if !ok || (tx.Status == TxStatusConfirmed && tx.BlockHash == block.Hash) {
+			continue
+		}
+		tx.Status = TxStatusConfirmed
+		tx.BlockHeight = block.Height
+		tx.BlockHash = block.Hash
+		tx.UpdateTime = t.now()
+		changed = append(changed, tx)
+	}
+	t.mu.Unlock()
+
+	t.notify(changed)
+}
+
+//RevertFrom 区块分叉时，在height及之后确认的交易重新标记为待确认
+func (t *TxTracker) RevertFrom(height uint64) {
+	changed := make([]*TrackedTx, 0)
+
+	t.mu.Lock()
+	for _, tx := range t.txs {
+		if tx.Status == TxStatusConfirmed && tx.BlockHeight >= height {
+			tx.Status = TxStatusPending
+			tx.BlockHeight = 0
+			tx.BlockHash = ""
+			tx.UpdateTime = t.now()
+			changed = append(changed, tx)
+		}
+	}
+	t.mu.Unlock()
+
+	t.notify(changed)
+}
+
+//RevertBlock 指定区块被替换时，在该区块确认的交易重新标记为待确认
+func (t *TxTracker) RevertBlock(blockHash string) {
+	changed := make([]*TrackedTx, 0)
+
+	t.mu.Lock()
+	for _, tx := range t.txs {
+		if tx.Status == TxStatusConfirmed && tx.BlockHash == blockHash {
+			tx.Status = TxStatusPending
+			tx.BlockHeight = 0
+			tx.BlockHash = ""
+			tx.UpdateTime = t.now()
+			changed = append(changed, tx)
+		}
+	}
+	t.mu.Unlock()
+
+	t.notify(changed)
+}
+
+//Replace 未上链的交易重建后标记为已替换，新交易广播后另行跟踪
+func (t *TxTracker) Replace(oldTxID, newTxID string) {
+	changed := make([]*TrackedTx, 0)
+
+	t.mu.Lock()
+	if tx, exist := t.txs[oldTxID]; exist && tx.Status != TxStatusConfirmed {
+		tx.Status = TxStatusReplaced
+		tx.ReplacedBy = newTxID
+		tx.UpdateTime = t.now()
+		changed = append(changed, tx)
+	}
+	t.mu.Unlock()
+
+	t.notify(changed)
+}
+
+//CheckTimeout 超时未上链的交易标记为已丢弃，状态结束超过保留时间的交易不再跟踪
+func (t *TxTracker) CheckTimeout() {
+	changed := make([]*TrackedTx, 0)
+	now := t.now()
+
+	t.mu.Lock()
+	for txid, tx := range t.txs {
+		if tx.Status == TxStatusPending {
+			if now.Sub(tx.SubmitTime) > t.DropTimeout {
+				tx.Status = TxStatusDropped
+				tx.UpdateTime = now
+				changed = append(changed, tx)
+			}
+			continue
+		}
+		if t.Retention > 0 && now.Sub(tx.UpdateTime) > t.Retention {
+			delete(t.txs, txid)
+		}
+	}
+	t.mu.Unlock()
+
+	t.notify(changed)
+}
+
+func (t *TxTracker) notify(changed []*TrackedTx) {
+	if len(changed) == 0 {
+		return
+	}
+
+	t.mu.RLock()
+	observers := make([]TxConfirmationObserver, 0, len(t.observers))
+	for o := range t.observers {
+		observers = append(observers, o)
+	}
+	notifies := make([]*TrackedTx, 0, len(changed))
+	for _, tx := range changed {
+		copied := *tx
+		notifies = append(notifies, &copied)
+	}
+	t.mu.RUnlock()
+
+	for _, o := range observers {
+		for _, tx := range notifies {
+			o.TxStatusNotify(tx)
+		}
+	}
+}
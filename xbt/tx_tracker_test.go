@@ -0,0 +1,132 @@
+package xbt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+type testTxObserver struct {
+	mu       sync.Mutex
+	notifies []*TrackedTx
+}
+
+func (o *testTxObserver) TxStatusNotify(tx *TrackedTx) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.notifies = append(o.notifies, tx)
+}
+
+func TestTxTracker_Timeout(t *testing.T) {
+	now := time.Now()
+	tracker := NewTxTracker(time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	observer := &testTxObserver{}
+	tracker.AddObserver(observer)
+
+	tracker.Track("tx1")
+	tracker.Track("tx2")
+
+	tracker.ProcessBlock(&Block{Height: 10, Hash: "h10", Transactions: []Transaction{{TxID: "tx1"}}})
+
+	now = now.Add(2 * time.Minute)
+	tracker.CheckTimeout()
+
+	tx1, _ := tracker.GetTx("tx1")
+	tx2, _ := tracker.GetTx("tx2")
+	if tx1.Status != TxStatusConfirmed || tx1.BlockHeight != 10 {
+		t.Errorf("tx1 = %+v, want confirmed at 10", tx1)
+	}
+	if tx2.Status != TxStatusDropped {
+		t.Errorf("tx2 = %+v, want dropped", tx2)
+	}
+	if len(observer.notifies) != 2 {
+		t.Errorf("notifies = %d, want 2", len(observer.notifies))
+	}
+
+	//分叉后重新等待确认
+	tracker.RevertFrom(10)
+	tx1, _ = tracker.GetTx("tx1")
+	if tx1.Status != TxStatusPending {
+		t.Errorf("tx1 = %+v, want pending after fork", tx1)
+	}
+
+	//只回滚被替换区块中的交易
+	tracker.Track("tx3")
+	tracker.ProcessBlock(&Block{Height: 11, Hash: "h11", Transactions: []Transaction{{TxID: "tx1"}}})
+	tracker.ProcessBlock(&Block{Height: 12, Hash: "h12", Transactions: []Transaction{{TxID: "tx3"}}})
+	tracker.RevertBlock("h11")
+	tx1, _ = tracker.GetTx("tx1")
+	tx3, _ := tracker.GetTx("tx3")
+	if tx1.Status != TxStatusPending || tx3.Status != TxStatusConfirmed {
+		t.Errorf("tx1 = %+v, tx3 = %+v after block h11 replaced", tx1, tx3)
+	}
+}
+
+func TestTxTracker_Retention(t *testing.T) {
+	now := time.Now()
+	tracker := NewTxTracker(time.Minute)
+	tracker.Retention = time.Hour
+	tracker.now = func() time.Time { return now }
+
+	tracker.Track("confirmed")
+	tracker.Track("dropped")
+	tracker.ProcessBlock(&Block{Height: 10, Hash: "h10", Transactions: []Transaction{{TxID: "confirmed"}}})
+	now = now.Add(2 * time.Minute)
+	tracker.CheckTimeout()
+	tracker.Track("pending")
+
+	//保留时间内仍可查询
+	now = now.Add(30 * time.Minute)
+	tracker.CheckTimeout()
+	for _, txid := range []string{"confirmed", "dropped", "pending"} {
+		if _, ok := tracker.GetTx(txid); !ok {
+			t.Errorf("%s should be tracked within retention", txid)
+		}
+	}
+
+	//超过保留时间后清除已结束的交易，待确认的交易不清除
+	now = now.Add(time.Hour)
+	tracker.CheckTimeout()
+	if _, ok := tracker.GetTx("confirmed"); ok {
+		t.Error("confirmed tx should be evicted after retention")
+	}
+	if _, ok := tracker.GetTx("dropped"); ok {
+		t.Error("dropped tx should be evicted after retention")
+	}
+	if tx, ok := tracker.GetTx("pending"); !ok || tx.Status != TxStatusDropped {
+		t.Errorf("pending tx = %+v, want dropped", tx)
+	}
+}
+
+func TestTxTracker_ScanBlockTask_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(2)
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+
+	observer := &testTxObserver{}
+	wm.TxTracker.AddObserver(observer)
+	wm.TxTracker.Track("submittedTx")
+
+	block := srv.AddBlock(xbtmock.Tx{Hash: "submittedTx", From: testOtherAddress, To: testDepositAddress, Amount: "1"})
+	srv.AddBlocks(1)
+
+	bs.ScanBlockTask()
+
+	tx, ok := wm.TxTracker.GetTx("submittedTx")
+	if !ok || tx.Status != TxStatusConfirmed || tx.BlockHeight != block.Height {
+		t.Errorf("tx = %+v, want confirmed at %d", tx, block.Height)
+	}
+	if len(observer.notifies) != 1 {
+		t.Errorf("notifies = %d, want 1", len(observer.notifies))
+	}
+}
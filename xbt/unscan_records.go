@@ -0,0 +1,243 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xbt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//未扫记录的失败原因
+const (
+	UnscanReasonExtractFailed = "extract_failed"  //交易提取失败
+	UnscanReasonNotifyFailed  = "notify_failed"   //通知观测者失败
+	UnscanReasonBlockNotFound = "block_not_found" //节点没有该高度的区块
+	UnscanReasonNodeError     = "node_error"      //获取区块时节点报错
+	UnscanReasonUnknown       = "unknown"         //旧版本保存的未扫记录
+)
+
+const (
+	defaultMaxRescanAttempts = 10
+	defaultRescanBackoff     = 30 * time.Second
+	defaultRescanMaxBackoff  = time.Hour
+)
+
+//UnscanReason 未扫记录的结构化原因，以json保存在UnscanRecord.Reason
+type UnscanReason struct {
+	Code      string           `json:"code"`
+	Message   string           `json:"message"`
+	Attempts  int              `json:"attempts"`          //已失败的次数
+	NextRetry int64            `json:"nextRetry"`         //下次重扫的时间，unix秒
+	Dead      bool             `json:"dead"`              //失败次数达到MaxRescanAttempts，不再自动重扫
+	Targets   []DeliveryTarget `json:"targets,omitempty"` //通知失败的观测者及sourceKey，重扫时只投递给这些目标
+}
+
+//UnscanRecordInfo 未扫记录及解析后的原因
+type UnscanRecordInfo struct {
+	ID          string `json:"id"`
+	BlockHeight uint64 `json:"blockHeight"`
+	TxID        string `json:"txid"`
+	UnscanReason
+}
+
+//parseUnscanReason 解析未扫记录的原因，旧版本的文本原因视为未重扫过
+func parseUnscanReason(record *openwallet.UnscanRecord) UnscanReason {
+	var reason UnscanReason
+	if err := json.Unmarshal([]byte(record.Reason), &reason); err != nil || len(reason.Code) == 0 {
+		return UnscanReason{Code: UnscanReasonUnknown, Message: record.Reason}
+	}
+	return reason
+}
+
+//rescanBackoff 第attempts次失败后的重扫间隔，指数增长
+func (bs *XBTBlockScanner) rescanBackoff(attempts int) time.Duration {
+	backoff := bs.RescanBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if bs.RescanMaxBackoff > 0 && backoff >= bs.RescanMaxBackoff {
+			return bs.RescanMaxBackoff
+		}
+	}
+	return backoff
+}
+
+//saveUnscanRecord 记录未扫区块，已有相同记录时累计失败次数并计算下次重扫时间
+func (bs *XBTBlockScanner) saveUnscanRecord(height uint64, txID, code, message string, targets ...DeliveryTarget) error {
+	bs.unscanMu.Lock()
+	defer bs.unscanMu.Unlock()
+
+	record := openwallet.NewUnscanRecord(height, txID, "", bs.wm.Symbol())
+
+	reason := UnscanReason{Code: code, Message: message, Attempts: 1, Targets: targets}
+	if list, err := bs.GetUnscanRecords(); err == nil {
+		for _, r := range list {
+			if r.ID == record.ID {
+				reason.Attempts = parseUnscanReason(r).Attempts + 1
+				break
+			}
+		}
+	}
+
+	reason.NextRetry = time.Now().Add(bs.rescanBackoff(reason.Attempts)).Unix()
+	if bs.MaxRescanAttempts > 0 && reason.Attempts >= bs.MaxRescanAttempts {
+		reason.Dead = true
+		bs.wm.Log.Std.Alert("block height: %d tx: %s failed %d times, stop rescanning; last error: %s", height, txID, reason.Attempts, message)
+	}
+
+	data, _ := json.Marshal(reason)
+	record.Reason = string(data)
+
+	err := bs.SaveUnscanRecord(record)
+	if err != nil {
+		bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err)
+	}
+	return err
+}
+
+//GetUnscanRecordInfos 查询未扫记录，deadOnly为true时只返回不再自动重扫的记录
+func (bs *XBTBlockScanner) GetUnscanRecordInfos(deadOnly bool) ([]*UnscanRecordInfo, error) {
+	list, err := bs.GetUnscanRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*UnscanRecordInfo, 0, len(list))
+	for _, r := range list {
+		info := &UnscanRecordInfo{
+			ID:           r.ID,
+			BlockHeight:  r.BlockHeight,
+			TxID:         r.TxID,
+			UnscanReason: parseUnscanReason(r),
+		}
+		if deadOnly && !info.Dead {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].BlockHeight < infos[j].BlockHeight
+	})
+
+	return infos, nil
+}
+
+//RetryUnscanRecord 立即重扫指定高度的未扫记录，忽略重扫间隔及不再自动重扫的状态
+func (bs *XBTBlockScanner) RetryUnscanRecord(height uint64) error {
+	list, err := bs.GetUnscanRecords()
+	if err != nil {
+		return err
+	}
+
+	records := make([]*openwallet.UnscanRecord, 0)
+	for _, r := range list {
+		if r.BlockHeight == height {
+			records = append(records, r)
+		}
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("unscan record of height %d not found", height)
+	}
+
+	return bs.rescanHeight(height, records)
+}
+
+//rescanHeight 重扫一个高度，成功时删除该高度的未扫记录，部分失败时只保留失败的记录
+func (bs *XBTBlockScanner) rescanHeight(height uint64, records []*openwallet.UnscanRecord) error {
+	bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", height)
+
+	block, err := bs.wm.ApiClient.getBlockByHeight(height)
+	if err != nil {
+		code := UnscanReasonNodeError
+		var notFound *NotFoundError
+		if errors.As(err, &notFound) {
+			code = UnscanReasonBlockNotFound
+		}
+		for _, r := range records {
+			bs.saveUnscanRecord(height, r.TxID, code, err.Error(), parseUnscanReason(r).Targets...)
+		}
+		return err
+	}
+
+	//没有交易的区块无需提取
+	if len(block.Transactions) == 0 {
+		return bs.DeleteUnscanRecord(height)
+	}
+
+	//只重新投递失败的交易及观测者
+	txs := block.Transactions
+	filter := redeliveryFilter(records)
+	if filter != nil {
+		txs = make([]Transaction, 0, len(filter))
+		for _, tx := range block.Transactions {
+			if _, exist := filter[tx.TxID]; exist {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	//没有需要提取的交易
+	if len(txs) == 0 {
+		return bs.DeleteUnscanRecord(height)
+	}
+
+	err = bs.batchExtractTransaction(block.Height, block.Hash, txs, false, bs.confirmedStatus(), filter)
+	if err != nil {
+		//提取或投递失败的交易已重新记录，只保留失败的观测者并累计各自的失败次数
+		failed := make(map[string]bool)
+		recorded := false
+		if list, e := bs.GetUnscanRecords(); e == nil {
+			for _, r := range list {
+				if r.BlockHeight == height && parseUnscanReason(r).Attempts > bs.attemptsOf(records, r.ID) {
+					failed[r.ID] = true
+					recorded = true
+				}
+			}
+		}
+		for _, r := range records {
+			if failed[r.ID] {
+				continue
+			}
+			if recorded {
+				//已成功投递的记录不再重扫
+				bs.BlockchainDAI.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
+			} else {
+				//失败未能记录时，保留原记录累计失败次数
+				reason := parseUnscanReason(r)
+				bs.saveUnscanRecord(height, r.TxID, reason.Code, err.Error(), reason.Targets...)
+			}
+		}
+		return err
+	}
+
+	//删除未扫记录
+	return bs.DeleteUnscanRecord(height)
+}
+
+//attemptsOf 重扫前记录的失败次数
+func (bs *XBTBlockScanner) attemptsOf(records []*openwallet.UnscanRecord, id string) int {
+	for _, r := range records {
+		if r.ID == id {
+			return parseUnscanReason(r).Attempts
+		}
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+package xbt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/xbt-adapter/xbt/xbtmock"
+)
+
+//testFailingObserver 前failTimes次提取通知返回错误
+type testFailingObserver struct {
+	*testMockObserver
+	failTimes int
+}
+
+func (o *testFailingObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	if o.failTimes > 0 {
+		o.failTimes--
+		o.mu.Unlock()
+		return errors.New("observer unavailable")
+	}
+	o.mu.Unlock()
+	return o.testMockObserver.BlockExtractDataNotify(sourceKey, data)
+}
+
+func TestXBTBlockScanner_rescanBackoff(t *testing.T) {
+	bs := &XBTBlockScanner{RescanBackoff: time.Second, RescanMaxBackoff: 10 * time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{30, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if got := bs.rescanBackoff(test.attempts); got != test.want {
+			t.Errorf("rescanBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestXBTBlockScanner_UnscanDeadLetter_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "1.1234567"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv, "maxRescanAttempts = 3", "rescanBackoff = \"1h\"")
+	bs := wm.Blockscanner
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	infos, err := bs.GetUnscanRecordInfos(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := srv.GetBlock(2).Txs[0]
+	if len(infos) != 1 || infos[0].TxID != bad.Hash || infos[0].Code != UnscanReasonExtractFailed ||
+		infos[0].Attempts != 1 || infos[0].NextRetry <= time.Now().Unix() || len(infos[0].Message) == 0 {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+
+	//未到重扫时间不重扫
+	bs.RescanFailedRecord()
+	if infos, _ := bs.GetUnscanRecordInfos(false); infos[0].Attempts != 1 {
+		t.Errorf("record rescanned before next retry: %+v", infos[0])
+	}
+
+	//人工重扫失败后按新的间隔重扫，失败次数达到上限后不再自动重扫
+	bs.RescanBackoff = 0
+	if err := bs.RetryUnscanRecord(2); err == nil {
+		t.Error("retry of a bad tx should fail")
+	}
+	bs.RescanFailedRecord()
+	dead, _ := bs.GetUnscanRecordInfos(true)
+	if len(dead) != 1 || dead[0].Attempts != 3 || !dead[0].Dead {
+		t.Fatalf("record should be dead: %+v", dead)
+	}
+
+	bs.RescanFailedRecord()
+	if infos, _ := bs.GetUnscanRecordInfos(false); infos[0].Attempts != 3 {
+		t.Errorf("dead record rescanned automatically: %+v", infos[0])
+	}
+	if status := bs.ScannerStatus(); status.DeadUnscanRecords != 1 {
+		t.Errorf("dead unscan records = %d, want 1", status.DeadUnscanRecords)
+	}
+
+	if err := bs.RetryUnscanRecord(3); err == nil {
+		t.Error("retry of a height without records should fail")
+	}
+}
+
+func TestXBTBlockScanner_UnscanNotifyFailed_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "3"})
+	srv.AddBlocks(1)
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+	observer := &testFailingObserver{testMockObserver: newTestMockObserver(), failTimes: 1}
+	bs.AddObserver(observer)
+
+	genesis := srv.GetBlock(1)
+	bs.SaveLocalNewBlock(genesis.Height, genesis.Hash)
+	bs.ScanBlockTask()
+
+	infos, _ := bs.GetUnscanRecordInfos(false)
+	if len(infos) != 1 || infos[0].BlockHeight != 2 || infos[0].Code != UnscanReasonNotifyFailed {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+	if n := len(observer.extractData(testAccountID)); n != 0 {
+		t.Errorf("notified %d records before retry", n)
+	}
+
+	//人工重扫忽略重扫时间，成功后删除记录
+	if err := bs.RetryUnscanRecord(2); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := bs.GetUnscanRecordInfos(false); len(infos) != 0 {
+		t.Errorf("unscan records not deleted: %+v", infos)
+	}
+	if n := len(observer.extractData(testAccountID)); n != 1 {
+		t.Errorf("notified %d records after retry, want 1", n)
+	}
+}
+
+func TestXBTBlockScanner_DeleteUnscanRecordNotFindTX_Mock(t *testing.T) {
+	srv := xbtmock.NewServer()
+	defer srv.Close()
+
+	srv.AddBlocks(1)
+	srv.AddBlock(xbtmock.Tx{From: testOtherAddress, To: testDepositAddress, Amount: "3"})
+
+	wm := testMockWalletManager(t, srv)
+	bs := wm.Blockscanner
+
+	tx := srv.GetBlock(2).Txs[0]
+	bs.saveUnscanRecord(2, tx.Hash, UnscanReasonExtractFailed, "test")
+	bs.saveUnscanRecord(2, "missing", UnscanReasonExtractFailed, "test")
+	bs.saveUnscanRecord(2, "", UnscanReasonNotifyFailed, "test")
+
+	if err := bs.DeleteUnscanRecordNotFindTX(); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, _ := bs.GetUnscanRecordInfos(false)
+	if len(infos) != 2 {
+		t.Fatalf("unexpected unscan records: %+v", infos)
+	}
+	for _, info := range infos {
+		if info.TxID == "missing" {
+			t.Errorf("record of missing tx not deleted: %+v", info)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"path/filepath"
+	"time"
 
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
@@ -147,14 +148,200 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 
 	wm.Config.FixedFee = c.String("fixedFee")
 
-	wm.ApiClient = NewClient(c.String("serverAPI"), false, wm.Config.Symbol, wm.Config.Decimal)
-	wm.XbtToolsClient = NewXbtToolsClient(c.String("xbtToolsAPI"), false, wm.Config.Symbol, wm.Config.Decimal)
+	feeSchedule, err := loadFeeSchedule(c)
+	if err != nil {
+		return err
+	}
+	wm.FeeSchedule = feeSchedule
+
+	senderSelector, err := NewSenderSelector(c.String("senderStrategy"))
+	if err != nil {
+		return err
+	}
+	wm.SenderSelector = senderSelector
+
+	nonceManager, err := NewNonceManager(c.String("nonceStrategy"), wm.Symbol())
+	if err != nil {
+		return err
+	}
+	wm.NonceManager = nonceManager
+
+	policy, err := loadTransportPolicy(c)
+	if err != nil {
+		return err
+	}
+
+	//serverAPI可配置多个节点，以逗号分隔
+	endpoints := ParseEndpoints(c.String("serverAPI"))
+	serverAPI := ""
+	if len(endpoints) > 0 {
+		serverAPI = endpoints[0]
+	}
+	wm.ApiClient = NewClient(serverAPI, false, wm.Config.Symbol, wm.Config.Decimal)
+	wm.ApiClient.Policy = policy
+	if len(endpoints) > 1 {
+		pool, err := loadEndpointPool(c, endpoints)
+		if err != nil {
+			return err
+		}
+		pool.Timeout = policy.Timeout
+		wm.ApiClient.Pool = pool
+	}
+
+	//xbt tools服务为可选项，只用于核对本地生成的地址
+	xbtToolsAPI := c.String("xbtToolsAPI")
+	if len(xbtToolsAPI) > 0 {
+		wm.XbtToolsClient = NewXbtToolsClient(xbtToolsAPI, false, wm.Config.Symbol, wm.Config.Decimal)
+		wm.XbtToolsClient.Policy = policy
+	}
+	wm.Config.XbtToolsCheck, _ = c.Bool("xbtToolsCheck")
 
 	wm.Config.DataDir = c.String("dataDir")
 
+	txDropTimeout := c.String("txDropTimeout")
+	if len(txDropTimeout) > 0 {
+		timeout, err := time.ParseDuration(txDropTimeout)
+		if err != nil {
+			return errors.New("wrong txDropTimeout : " + txDropTimeout)
+		}
+		wm.TxTracker.DropTimeout = timeout
+	}
+
+	txRetention := c.String("txRetention")
+	if len(txRetention) > 0 {
+		retention, err := time.ParseDuration(txRetention)
+		if err != nil {
+			return errors.New("wrong txRetention : " + txRetention)
+		}
+		wm.TxTracker.Retention = retention
+	}
+
+	txValidity := c.String("txValidity")
+	if len(txValidity) > 0 {
+		validity, err := time.ParseDuration(txValidity)
+		if err != nil {
+			return errors.New("wrong txValidity : " + txValidity)
+		}
+		wm.Config.TxValidity = validity
+	}
+
+	blockRangeSize, err := c.Int64("blockRangeSize")
+	if err == nil && blockRangeSize > 0 {
+		wm.Blockscanner.BlockRangeSize = uint64(blockRangeSize)
+	}
+
+	confirmations, err := c.Int64("confirmations")
+	if err == nil && confirmations > 0 {
+		wm.Blockscanner.Confirmations = uint64(confirmations)
+	}
+	wm.Blockscanner.NotifyUnconfirmed, _ = c.Bool("notifyUnconfirmed")
+
+	rescanLastBlockCount, err := c.Int64("rescanLastBlockCount")
+	if err == nil && rescanLastBlockCount >= 0 {
+		wm.Blockscanner.RescanLastBlockCount = uint64(rescanLastBlockCount)
+	}
+
+	maxRescanAttempts, err := c.Int("maxRescanAttempts")
+	if err == nil && maxRescanAttempts >= 0 {
+		wm.Blockscanner.MaxRescanAttempts = maxRescanAttempts
+	}
+
+	rescanBackoff := c.String("rescanBackoff")
+	if len(rescanBackoff) > 0 {
+		d, err := time.ParseDuration(rescanBackoff)
+		if err != nil {
+			return errors.New("wrong rescanBackoff : " + rescanBackoff)
+		}
+		wm.Blockscanner.RescanBackoff = d
+	}
+
+	rescanMaxBackoff := c.String("rescanMaxBackoff")
+	if len(rescanMaxBackoff) > 0 {
+		d, err := time.ParseDuration(rescanMaxBackoff)
+		if err != nil {
+			return errors.New("wrong rescanMaxBackoff : " + rescanMaxBackoff)
+		}
+		wm.Blockscanner.RescanMaxBackoff = d
+	}
+
+	wm.Blockscanner.IsScanMemPool, _ = c.Bool("scanMemPool")
+
+	maxReorgDepth, err := c.Int64("maxReorgDepth")
+	if err == nil && maxReorgDepth >= 0 {
+		wm.Blockscanner.MaxReorgDepth = uint64(maxReorgDepth)
+	}
+
+	maxScanLag, err := c.Int64("maxScanLag")
+	if err == nil && maxScanLag >= 0 {
+		wm.Blockscanner.MaxScanLag = uint64(maxScanLag)
+	}
+
+	stallTimeout := c.String("scanStallTimeout")
+	if len(stallTimeout) > 0 {
+		d, err := time.ParseDuration(stallTimeout)
+		if err != nil {
+			return errors.New("wrong scanStallTimeout : " + stallTimeout)
+		}
+		wm.Blockscanner.StallTimeout = d
+	}
+
+	backfillWorkers, err := c.Int("backfillWorkers")
+	if err == nil && backfillWorkers > 0 {
+		wm.Blockscanner.BackfillWorkers = backfillWorkers
+	}
+
 	//数据文件夹
 	wm.Config.makeDataDir()
 
 	return nil
 }
 
+//loadTransportPolicy 读取HTTP请求的超时及重试配置
+func loadTransportPolicy(c config.Configer) (*TransportPolicy, error) {
+	policy := NewTransportPolicy()
+
+	durations := map[string]*time.Duration{
+		"requestTimeout":    &policy.Timeout,
+		"requestBackoff":    &policy.Backoff,
+		"requestMaxBackoff": &policy.MaxBackoff,
+	}
+	for key, value := range durations {
+		str := c.String(key)
+		if len(str) == 0 {
+			continue
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return nil, errors.New("wrong " + key + " : " + str)
+		}
+		*value = d
+	}
+
+	maxAttempts, err := c.Int("requestMaxAttempts")
+	if err == nil && maxAttempts > 0 {
+		policy.MaxAttempts = maxAttempts
+	}
+
+	return policy, nil
+}
+
+//loadEndpointPool 读取多节点的健康检查配置
+func loadEndpointPool(c config.Configer, endpoints []string) (*EndpointPool, error) {
+	pool := NewEndpointPool(endpoints)
+
+	maxLag, err := c.Int64("nodeMaxLag")
+	if err == nil && maxLag >= 0 {
+		pool.MaxLag = uint64(maxLag)
+	}
+
+	checkInterval := c.String("nodeCheckInterval")
+	if len(checkInterval) > 0 {
+		d, err := time.ParseDuration(checkInterval)
+		if err != nil {
+			return nil, errors.New("wrong nodeCheckInterval : " + checkInterval)
+		}
+		pool.CheckInterval = d
+	}
+
+	return pool, nil
+}
@@ -1,6 +1,8 @@
 package xbt
 
 import (
+	"encoding/hex"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
 	"github.com/imroc/req"
 	"github.com/tidwall/gjson"
 )
@@ -16,6 +18,7 @@ type XbtToolsClient struct {
 	client      *req.Req
 	Symbol      string
 	Decimal     int32
+	Policy      *TransportPolicy
 }
 
 func NewXbtToolsClient(url string /*token string,*/, debug bool, symbol string, decimal int32) *XbtToolsClient {
@@ -31,6 +34,7 @@ func NewXbtToolsClient(url string /*token string,*/, debug bool, symbol string,
 	c.client = api
 	c.Symbol = symbol
 	c.Decimal = decimal
+	c.Policy = NewTransportPolicy()
 
 	return &c
 }
@@ -40,28 +44,28 @@ func (c *XbtToolsClient) PostCall(path string, v map[string]interface{}) (*gjson
 		log.Debug("Start Request API, url : ", path, ", body : ", v)
 	}
 
-	r, err := req.Post(c.BaseURL+path, req.BodyJSON(&v))
+	r, err := c.Policy.post(c.client, c.BaseURL+path, req.BodyJSON(&v))
 
 	if c.Debug {
 		log.Std.Info("Request API Completed")
 	}
 
 	if c.Debug {
-		log.Debugf("%+v\n", r)
+		log.Debugf("%s\n", r)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
+	resp := gjson.ParseBytes(r)
 
 	result := resp
 
 	return &result, nil
 }
 
-// 获取当前最高区块
+// 通过xbt tools服务把公钥转为地址，仅用于比对本地实现的结果
 func (c *XbtToolsClient) getAddressByPublicKey(publicKey string) (string, error) {
 	body := map[string]interface{}{
 		"public" : publicKey,
@@ -75,4 +79,26 @@ func (c *XbtToolsClient) getAddressByPublicKey(publicKey string) (string, error)
 	address := gjson.Get(resp.Raw, "address").String()
 
 	return address, nil
-}
\ No newline at end of file
+}
+
+//crossCheckAddress 开启xbtToolsCheck时，用xbt tools服务核对本地生成的地址，不一致只记录日志
+func (wm *WalletManager) crossCheckAddress(publicKey []byte, address string) {
+	if !wm.Config.XbtToolsCheck || wm.XbtToolsClient == nil {
+		return
+	}
+
+	pub, err := xbtTransaction.NormalizePublicKey(publicKey)
+	if err != nil {
+		return
+	}
+
+	toolsAddress, err := wm.XbtToolsClient.getAddressByPublicKey(hex.EncodeToString(pub))
+	if err != nil {
+		wm.Log.Std.Error("xbt tools cross check failed; unexpected error: %v", err)
+		return
+	}
+
+	if toolsAddress != address {
+		wm.Log.Std.Error("xbt tools cross check mismatch, public key: %s, local address: %s, xbt tools address: %s", hex.EncodeToString(pub), address, toolsAddress)
+	}
+}
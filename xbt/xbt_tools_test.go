@@ -1,12 +1,18 @@
 package xbt
 
-import "testing"
-
-const (
-	xbtToolsUrl = "http://127.0.0.1:3000" //xbt-tools
+import (
+	"os"
+	"testing"
 )
 
+//xbtToolsUrl xbt-tools服务地址，通过环境变量XBT_TOOLS_URL设置，如http://127.0.0.1:3000，未设置时跳过测试
+var xbtToolsUrl = os.Getenv("XBT_TOOLS_URL")
+
 func TestXbtToolsPostCall(t *testing.T) {
+	if len(xbtToolsUrl) == 0 {
+		t.Skip("XBT_TOOLS_URL is not set")
+	}
+
 	tw := NewClient(xbtToolsUrl, true, symbol, currencyDecimal)
 
 	body := map[string]interface{}{
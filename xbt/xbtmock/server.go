@@ -0,0 +1,498 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+//Package xbtmock 提供一个有状态的XBT节点模拟服务，用于离线测试扫块、解析及广播流程
+package xbtmock
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/xbt-adapter/xbtTransaction"
+)
+
+const (
+	PathBlockHeight      = "/open/block/height"
+	PathBlockRange       = "/open/block/range"
+	PathBalance          = "/open/balance"
+	PathTxSend           = "/open/tx/send"
+	PathTxPending        = "/open/tx/pending"
+	PathTxFee            = "/open/tx/fee"
+	PathAddressPublicKey = "/account/address/public"
+)
+
+//Tx 区块中的交易
+type Tx struct {
+	Hash   string
+	From   string
+	To     string
+	Amount string
+	Fee    string
+}
+
+//Block 模拟节点的区块
+type Block struct {
+	Height   uint64
+	Hash     string
+	PrevHash string
+	Time     uint64
+	Txs      []Tx
+}
+
+//Fault 注入的错误，Times为0表示一直生效，直到ClearFaults
+type Fault struct {
+	Code       int           //返回的业务code，0表示不修改
+	Message    string        //返回的message
+	HTTPStatus int           //返回的HTTP状态码，0表示200
+	Delay      time.Duration //响应前的延迟
+	Times      int           //生效次数
+}
+
+//Server 有状态的XBT节点模拟服务
+type Server struct {
+	*httptest.Server
+
+	TimeInMillis bool //区块时间以毫秒返回
+
+	mu        sync.Mutex
+	blocks    []*Block
+	balances  map[string]string
+	submitted []*xbtTransaction.TxStruct
+	pending   []*xbtTransaction.TxStruct
+	faults    map[string][]*Fault
+	requests  map[string]int
+	forkSalt  int
+	fee       string
+	rangeMax  int
+	blockTime uint64
+}
+
+//NewServer 启动模拟服务，使用完毕需要调用Close
+func NewServer() *Server {
+	s := &Server{
+		balances:  make(map[string]string),
+		faults:    make(map[string][]*Fault),
+		requests:  make(map[string]int),
+		blockTime: uint64(time.Date(2021, 4, 16, 0, 0, 0, 0, time.UTC).Unix()),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathBlockHeight, s.handle(PathBlockHeight, s.blockHeight))
+	mux.HandleFunc(PathBlockRange, s.handle(PathBlockRange, s.blockRange))
+	mux.HandleFunc(PathBalance, s.handle(PathBalance, s.balance))
+	mux.HandleFunc(PathTxSend, s.handle(PathTxSend, s.txSend))
+	mux.HandleFunc(PathTxPending, s.handle(PathTxPending, s.txPending))
+	mux.HandleFunc(PathTxFee, s.handle(PathTxFee, s.txFee))
+	mux.HandleFunc(PathAddressPublicKey, s.handle(PathAddressPublicKey, s.addressByPublicKey))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+/******************* 脚本接口 *******************/
+
+//AddBlock 在链尾追加一个包含txs的区块
+func (s *Server) AddBlock(txs ...Tx) *Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addBlock(txs)
+}
+
+//AddBlocks 在链尾追加n个空区块
+func (s *Server) AddBlocks(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.addBlock(nil)
+	}
+}
+
+//Fork 删除height及之后的区块，之后追加的区块hash与原链不同，用于模拟分叉
+func (s *Server) Fork(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height == 0 {
+		height = 1
+	}
+	if int(height-1) < len(s.blocks) {
+		s.blocks = s.blocks[:height-1]
+	}
+	s.forkSalt++
+}
+
+//MineSubmitted 把已广播未打包的交易打包到新区块
+func (s *Server) MineSubmitted() *Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txs := make([]Tx, 0, len(s.pending))
+	for _, ts := range s.pending {
+		txs = append(txs, Tx{
+			Hash:   ts.Hash,
+			From:   senderOfTx(ts),
+			To:     ts.To,
+			Amount: ts.Amount.String(),
+			Fee:    ts.Fee.String(),
+		})
+	}
+	s.pending = nil
+
+	return s.addBlock(txs)
+}
+
+//Height 当前最高区块高度
+func (s *Server) Height() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return uint64(len(s.blocks))
+}
+
+//GetBlock 获取指定高度的区块
+func (s *Server) GetBlock(height uint64) *Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height == 0 || int(height) > len(s.blocks) {
+		return nil
+	}
+	return s.blocks[height-1]
+}
+
+//SetBalance 设置地址余额，amount为带小数的表示
+func (s *Server) SetBalance(address, amount string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.balances[address] = amount
+}
+
+//SetRangeLimit 区块范围接口最多返回的区块数，0为不限制，用于模拟节点截断的响应
+func (s *Server) SetRangeLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rangeMax = n
+}
+
+//SetTxFee 设置手续费接口返回的手续费，为空时接口返回not found
+func (s *Server) SetTxFee(fee string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fee = fee
+}
+
+//Submitted 已广播的交易
+func (s *Server) Submitted() []*xbtTransaction.TxStruct {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*xbtTransaction.TxStruct{}, s.submitted...)
+}
+
+//InjectFault 给指定接口注入错误，多个错误按注入顺序生效
+func (s *Server) InjectFault(path string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := fault
+	s.faults[path] = append(s.faults[path], &f)
+}
+
+//ClearFaults 清除所有注入的错误
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = make(map[string][]*Fault)
+}
+
+//Requests 指定接口收到的请求次数
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+func (s *Server) addBlock(txs []Tx) *Block {
+	height := uint64(len(s.blocks)) + 1
+	prevHash := ""
+	if len(s.blocks) > 0 {
+		prevHash = s.blocks[len(s.blocks)-1].Hash
+	}
+
+	for i := range txs {
+		if len(txs[i].Hash) == 0 {
+			txs[i].Hash = hashString("tx", strconv.FormatUint(height, 10), strconv.Itoa(i), strconv.Itoa(s.forkSalt))
+		}
+		if len(txs[i].Fee) == 0 {
+			txs[i].Fee = "0.1"
+		}
+	}
+
+	block := &Block{
+		Height:   height,
+		Hash:     hashString("block", strconv.FormatUint(height, 10), prevHash, strconv.Itoa(s.forkSalt)),
+		PrevHash: prevHash,
+		Time:     s.blockTime + height*10,
+		Txs:      txs,
+	}
+	s.blocks = append(s.blocks, block)
+	return block
+}
+
+/******************* HTTP接口 *******************/
+
+type handlerFunc func(body []byte) (interface{}, int, string)
+
+//handle 统一处理错误注入及返回格式：{"code":200,"data":...}
+func (s *Server) handle(path string, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.requests[path]++
+		fault := s.takeFault(path)
+		s.mu.Unlock()
+
+		if fault != nil {
+			if fault.Delay > 0 {
+				time.Sleep(fault.Delay)
+			}
+			if fault.HTTPStatus != 0 && fault.HTTPStatus != http.StatusOK {
+				w.WriteHeader(fault.HTTPStatus)
+				writeJSON(w, map[string]interface{}{"code": fault.HTTPStatus, "message": fault.Message})
+				return
+			}
+			if fault.Code != 0 {
+				writeJSON(w, map[string]interface{}{"code": fault.Code, "message": fault.Message})
+				return
+			}
+		}
+
+		data, code, message := h(body)
+		if code != http.StatusOK {
+			writeJSON(w, map[string]interface{}{"code": code, "message": message})
+			return
+		}
+
+		if path == PathAddressPublicKey {
+			writeJSON(w, data)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"code": code, "data": data})
+	}
+}
+
+func (s *Server) takeFault(path string) *Fault {
+	list := s.faults[path]
+	if len(list) == 0 {
+		return nil
+	}
+
+	f := list[0]
+	if f.Times > 0 {
+		f.Times--
+		if f.Times == 0 {
+			s.faults[path] = list[1:]
+		}
+	}
+	return f
+}
+
+func (s *Server) blockHeight(body []byte) (interface{}, int, string) {
+	return s.Height(), http.StatusOK, ""
+}
+
+func (s *Server) blockRange(body []byte) (interface{}, int, string) {
+	var req struct {
+		Start uint64 `json:"start"`
+		End   uint64 `json:"end"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]interface{}, 0)
+	for h := req.Start; h <= req.End && h > 0 && int(h) <= len(s.blocks); h++ {
+		if s.rangeMax > 0 && len(result) >= s.rangeMax {
+			break
+		}
+		result = append(result, blockJSON(s.blocks[h-1], s.TimeInMillis))
+	}
+	return result, http.StatusOK, ""
+}
+
+func (s *Server) balance(body []byte) (interface{}, int, string) {
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, ok := s.balances[req.Address]
+	if !ok {
+		balance = "0"
+	}
+	//地址已使用的最大nonce
+	nonce := uint64(0)
+	for _, ts := range s.submitted {
+		if senderOfTx(ts) == req.Address && ts.Nonce > nonce {
+			nonce = ts.Nonce
+		}
+	}
+	return map[string]interface{}{"address": req.Address, "balance": json.Number(balance), "nonce": nonce}, http.StatusOK, ""
+}
+
+func (s *Server) txSend(body []byte) (interface{}, int, string) {
+	req, err := xbtTransaction.DecodeSendTxRequest(string(body))
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	ts, err := req.TxStruct()
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sender := senderOfTx(ts)
+	for _, sent := range s.submitted {
+		if sent.Nonce == ts.Nonce && senderOfTx(sent) == sender {
+			return nil, http.StatusBadRequest, "nonce already used"
+		}
+	}
+
+	s.submitted = append(s.submitted, ts)
+	s.pending = append(s.pending, ts)
+	return ts.Hash, http.StatusOK, ""
+}
+
+func (s *Server) txFee(body []byte) (interface{}, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.fee) == 0 {
+		return nil, http.StatusNotFound, "fee not found"
+	}
+	return map[string]interface{}{"fee": json.Number(s.fee)}, http.StatusOK, ""
+}
+
+func (s *Server) txPending(body []byte) (interface{}, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txs := make([]interface{}, 0, len(s.pending))
+	for _, ts := range s.pending {
+		txs = append(txs, map[string]interface{}{
+			"hash":            ts.Hash,
+			"send_address":    senderOfTx(ts),
+			"receive_address": ts.To,
+			"amount":          json.Number(ts.Amount.String()),
+			"fee":             json.Number(ts.Fee.String()),
+			"time":            ts.Time,
+		})
+	}
+	return txs, http.StatusOK, ""
+}
+
+func (s *Server) addressByPublicKey(body []byte) (interface{}, int, string) {
+	var req struct {
+		Public string `json:"public"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	pub, err := hex.DecodeString(req.Public)
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	address, err := xbtTransaction.GetAddressByPublicKey(pub)
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+	return map[string]interface{}{"address": address}, http.StatusOK, ""
+}
+
+func blockJSON(b *Block, millis bool) map[string]interface{} {
+	txs := make([]interface{}, 0, len(b.Txs))
+	for _, tx := range b.Txs {
+		txs = append(txs, map[string]interface{}{
+			"hash":            tx.Hash,
+			"send_address":    tx.From,
+			"receive_address": tx.To,
+			"amount":          json.Number(tx.Amount),
+			"fee":             json.Number(tx.Fee),
+		})
+	}
+
+	blockTime := b.Time
+	if millis {
+		blockTime = blockTime * 1000
+	}
+
+	return map[string]interface{}{
+		"hash":      b.Hash,
+		"prev_hash": b.PrevHash,
+		"height":    b.Height,
+		"time":      blockTime,
+		"tx":        txs,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+//senderOfTx 从签名中的公钥还原发送地址
+func senderOfTx(ts *xbtTransaction.TxStruct) string {
+	parts := strings.Split(ts.Sig, "@")
+	if len(parts) != 2 {
+		return ""
+	}
+	pub, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	address, _ := xbtTransaction.GetAddressByPublicKey(pub)
+	return address
+}
+
+func hashString(parts ...string) string {
+	return hex.EncodeToString(owcrypt.Hash([]byte(strings.Join(parts, "_")), 0, owcrypt.HASH_ALG_SHA3_256))
+}
@@ -0,0 +1,76 @@
+package xbtmock
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func post(t *testing.T, s *Server, path string, body interface{}) gjson.Result {
+	j, _ := json.Marshal(body)
+	resp, err := http.Post(s.URL+path, "application/json", bytes.NewReader(j))
+	if err != nil {
+		t.Fatalf("post %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return gjson.ParseBytes(buf.Bytes())
+}
+
+func TestServer_Blocks(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddBlocks(2)
+	b3 := s.AddBlock(Tx{From: "xBfrom", To: "xBto", Amount: "1.5"})
+
+	if r := post(t, s, PathBlockHeight, map[string]interface{}{}); r.Get("data").Uint() != 3 {
+		t.Errorf("unexpected height: %s", r.Raw)
+	}
+
+	r := post(t, s, PathBlockRange, map[string]interface{}{"start": 2, "end": 5})
+	blocks := r.Get("data").Array()
+	if len(blocks) != 2 {
+		t.Fatalf("unexpected blocks: %s", r.Raw)
+	}
+	if blocks[1].Get("prev_hash").String() != blocks[0].Get("hash").String() {
+		t.Errorf("blocks are not linked: %s", r.Raw)
+	}
+	if blocks[1].Get("tx.0.amount").String() != "1.5" || blocks[1].Get("hash").String() != b3.Hash {
+		t.Errorf("unexpected block 3: %s", blocks[1].Raw)
+	}
+
+	//分叉后同一高度的hash发生变化
+	s.Fork(3)
+	s.AddBlock()
+	if s.GetBlock(3).Hash == b3.Hash || s.GetBlock(3).PrevHash != s.GetBlock(2).Hash {
+		t.Errorf("fork did not replace block 3")
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.SetBalance("xBaddr", "12.345")
+	s.InjectFault(PathBalance, Fault{Code: 500, Message: "server busy", Times: 1})
+
+	r := post(t, s, PathBalance, map[string]interface{}{"address": "xBaddr"})
+	if r.Get("code").Int() != 500 || r.Get("message").String() != "server busy" {
+		t.Errorf("fault was not injected: %s", r.Raw)
+	}
+
+	r = post(t, s, PathBalance, map[string]interface{}{"address": "xBaddr"})
+	if r.Get("code").Int() != 200 || r.Get("data.balance").String() != "12.345" {
+		t.Errorf("unexpected balance: %s", r.Raw)
+	}
+
+	if s.Requests(PathBalance) != 2 {
+		t.Errorf("unexpected requests count: %d", s.Requests(PathBalance))
+	}
+}
@@ -0,0 +1,76 @@
+package xbtTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+const (
+	XbtAddressPrefix = "xB"
+	XbtAddressLength = 42
+)
+
+// NormalizePublicKey 公钥统一转为65字节的非压缩格式（04开头）
+func NormalizePublicKey(publicKey []byte) ([]byte, error) {
+	switch len(publicKey) {
+	case 33:
+		pub := owcrypt.PointDecompress(publicKey, owcrypt.ECC_CURVE_SECP256K1)
+		if len(pub) != 65 {
+			return nil, errors.New("invalid compressed public key")
+		}
+		return pub, nil
+	case 64:
+		return append([]byte{0x04}, publicKey...), nil
+	case 65:
+		if publicKey[0] != 0x04 {
+			return nil, errors.New("invalid uncompressed public key")
+		}
+		return publicKey, nil
+	default:
+		return nil, errors.New("invalid public key length : " + strconv.Itoa(len(publicKey)))
+	}
+}
+
+// GetAddressByPublicKey 公钥转地址，SHA3-256(非压缩公钥)取前20字节，再加大小写校验
+func GetAddressByPublicKey(publicKey []byte) (string, error) {
+	pub, err := NormalizePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_SHA3_256)
+
+	return ChecksumAddress(XbtAddressPrefix + hex.EncodeToString(hash[:20]))
+}
+
+// ChecksumAddress 按SHA3-256校验码重新生成地址的大小写
+func ChecksumAddress(address string) (string, error) {
+	if len(address) != XbtAddressLength || !strings.EqualFold(address[:2], XbtAddressPrefix) {
+		return "", errors.New("wrong address")
+	}
+
+	content := strings.ToLower(address[2:])
+	if _, err := hex.DecodeString(content); err != nil {
+		return "", errors.New("wrong address")
+	}
+
+	hash := owcrypt.Hash([]byte(content), 0, owcrypt.HASH_ALG_SHA3_256)
+	hashCode := hex.EncodeToString(hash)
+	hashCode = hashCode[len(hashCode)-len(content):]
+
+	result := XbtAddressPrefix
+	for i := 0; i < len(hashCode); i++ {
+		n, _ := strconv.ParseUint(hashCode[i:i+1], 16, 32)
+		if n >= 8 {
+			result = result + strings.ToUpper(content[i:i+1])
+		} else {
+			result = result + content[i:i+1]
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,41 @@
+package xbtTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func Test_GetAddressByPublicKey(t *testing.T) {
+	want := "xB1CE3Ff24Bbe10dc457320D0BB3602d5C79F844a5"
+
+	compressed, _ := hex.DecodeString("0265ff85a638b555ad5f15359ef0d80688452bd4dae3a29ecdf53e74b76862a6f2")
+	uncompressed, _ := hex.DecodeString("0465ff85a638b555ad5f15359ef0d80688452bd4dae3a29ecdf53e74b76862a6f269d414c1154d28c35b0fec694bbf6c27d2fb0405ea3a84e45b04621dd42b3152")
+
+	for _, pub := range [][]byte{compressed, uncompressed, uncompressed[1:]} {
+		addr, err := GetAddressByPublicKey(pub)
+		if err != nil {
+			t.Fatalf("GetAddressByPublicKey failed: %v", err)
+		}
+		if addr != want {
+			t.Errorf("address = %s, want %s", addr, want)
+		}
+	}
+
+	if _, err := GetAddressByPublicKey(compressed[:32]); err == nil {
+		t.Errorf("expected error for invalid public key length")
+	}
+}
+
+func Test_ChecksumAddress(t *testing.T) {
+	addr, err := ChecksumAddress("xb1ce3ff24bbe10dc457320d0bb3602d5c79f844a5")
+	if err != nil {
+		t.Fatalf("ChecksumAddress failed: %v", err)
+	}
+	if addr != "xB1CE3Ff24Bbe10dc457320D0BB3602d5C79F844a5" {
+		t.Errorf("unexpected checksum address: %s", addr)
+	}
+
+	if _, err := ChecksumAddress("xB1CE3Ff24Bbe10dc457320D0BB3602d5C79F844a5123"); err == nil {
+		t.Errorf("expected error for wrong address length")
+	}
+}
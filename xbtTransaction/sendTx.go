@@ -0,0 +1,138 @@
+package xbtTransaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInvalidSendPayload  = errors.New("invalid send transaction payload")
+	ErrSendPayloadMismatch = errors.New("send transaction payload does not match signed transaction")
+)
+
+//SendTxRequest 提交交易的请求体，格式：{"tx":{...}}
+type SendTxRequest struct {
+	Tx SendTxPayload `json:"tx"`
+}
+
+//SendTxPayload 提交的交易，金额及手续费以json数字输出，与交易原文的格式一致
+type SendTxPayload struct {
+	Hash   string      `json:"hash"`
+	To     string      `json:"to"`
+	Amount json.Number `json:"amount"`
+	Fee    json.Number `json:"fee"`
+	Nonce  uint64      `json:"nonce"`
+	Time   uint64      `json:"time"`
+	Sig    string      `json:"sig"`
+}
+
+//NewSendTxRequest 根据已签名的交易生成提交请求
+func NewSendTxRequest(ts *TxStruct) (*SendTxRequest, error) {
+	if ts == nil || ts.Amount == nil || ts.Fee == nil {
+		return nil, fmt.Errorf("%w: amount or fee is empty", ErrInvalidSendPayload)
+	}
+
+	return &SendTxRequest{
+		Tx: SendTxPayload{
+			Hash:   ts.Hash,
+			To:     ts.To,
+			Amount: json.Number(ts.Amount.String()),
+			Fee:    json.Number(ts.Fee.String()),
+			Nonce:  ts.Nonce,
+			Time:   ts.Time,
+			Sig:    ts.Sig,
+		},
+	}, nil
+}
+
+//EncodeSendTxRequest 已签名交易的规范请求体，提交、日志及测试共用
+func EncodeSendTxRequest(ts *TxStruct) (string, error) {
+	req, err := NewSendTxRequest(ts)
+	if err != nil {
+		return "", err
+	}
+	return req.Encode()
+}
+
+//Encode 规范编码：字段顺序固定，不转义html字符，没有多余的空白
+func (r *SendTxRequest) Encode() (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSendPayload, err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+//DecodeSendTxRequest 解析提交请求体
+func DecodeSendTxRequest(data string) (*SendTxRequest, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	req := &SendTxRequest{}
+	if err := dec.Decode(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSendPayload, err)
+	}
+	if _, err := req.TxStruct(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+//TxStruct 转换为交易结构
+func (r *SendTxRequest) TxStruct() (*TxStruct, error) {
+	amount, err := decimal.NewFromString(r.Tx.Amount.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: amount: %v", ErrInvalidSendPayload, err)
+	}
+	fee, err := decimal.NewFromString(r.Tx.Fee.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: fee: %v", ErrInvalidSendPayload, err)
+	}
+
+	return &TxStruct{
+		Hash:   r.Tx.Hash,
+		To:     r.Tx.To,
+		Amount: &amount,
+		Fee:    &fee,
+		Nonce:  r.Tx.Nonce,
+		Time:   r.Tx.Time,
+		Sig:    r.Tx.Sig,
+	}, nil
+}
+
+//Verify 核对请求体与已签名的交易是否一致，金额及手续费按数值比较
+func (r *SendTxRequest) Verify(ts *TxStruct) error {
+	if ts == nil || ts.Amount == nil || ts.Fee == nil {
+		return fmt.Errorf("%w: amount or fee is empty", ErrInvalidSendPayload)
+	}
+
+	got, err := r.TxStruct()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case got.Hash != ts.Hash:
+		return fmt.Errorf("%w: hash %s, expected %s", ErrSendPayloadMismatch, got.Hash, ts.Hash)
+	case got.To != ts.To:
+		return fmt.Errorf("%w: to %s, expected %s", ErrSendPayloadMismatch, got.To, ts.To)
+	case !got.Amount.Equal(*ts.Amount):
+		return fmt.Errorf("%w: amount %s, expected %s", ErrSendPayloadMismatch, got.Amount, ts.Amount)
+	case !got.Fee.Equal(*ts.Fee):
+		return fmt.Errorf("%w: fee %s, expected %s", ErrSendPayloadMismatch, got.Fee, ts.Fee)
+	case got.Nonce != ts.Nonce:
+		return fmt.Errorf("%w: nonce %d, expected %d", ErrSendPayloadMismatch, got.Nonce, ts.Nonce)
+	case got.Time != ts.Time:
+		return fmt.Errorf("%w: time %d, expected %d", ErrSendPayloadMismatch, got.Time, ts.Time)
+	case got.Sig != ts.Sig:
+		return fmt.Errorf("%w: sig %s, expected %s", ErrSendPayloadMismatch, got.Sig, ts.Sig)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package xbtTransaction
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func testSignedTxStruct() *TxStruct {
+	amount, _ := decimal.NewFromString("0.01234")
+	fee, _ := decimal.NewFromString("0.1")
+	return &TxStruct{
+		Hash:   "67ce776f3a8e10bd99c9f4db845c3bcb6e00146c5de06b50b2fb0c0a96a0dbe0",
+		To:     "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B",
+		Amount: &amount,
+		Fee:    &fee,
+		Nonce:  1,
+		Time:   1600000000000,
+		Sig:    "3044@04ab",
+	}
+}
+
+func TestEncodeSendTxRequest(t *testing.T) {
+	ts := testSignedTxStruct()
+
+	got, err := EncodeSendTxRequest(ts)
+	if err != nil {
+		t.Fatalf("EncodeSendTxRequest failed: %v", err)
+	}
+	want := `{"tx":{"hash":"67ce776f3a8e10bd99c9f4db845c3bcb6e00146c5de06b50b2fb0c0a96a0dbe0","to":"xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B","amount":0.01234,"fee":0.1,"nonce":1,"time":1600000000000,"sig":"3044@04ab"}}`
+	if got != want {
+		t.Fatalf("payload = %s, want %s", got, want)
+	}
+
+	req, err := DecodeSendTxRequest(got)
+	if err != nil {
+		t.Fatalf("DecodeSendTxRequest failed: %v", err)
+	}
+	if err := req.Verify(ts); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+}
+
+func TestEncodeSendTxRequest_Escape(t *testing.T) {
+	ts := testSignedTxStruct()
+	ts.To = `xB"<bad>\`
+	ts.Sig = "sig\"@04"
+
+	payload, err := EncodeSendTxRequest(ts)
+	if err != nil {
+		t.Fatalf("EncodeSendTxRequest failed: %v", err)
+	}
+	if !json.Valid([]byte(payload)) {
+		t.Fatalf("invalid json: %s", payload)
+	}
+
+	req, err := DecodeSendTxRequest(payload)
+	if err != nil {
+		t.Fatalf("DecodeSendTxRequest failed: %v", err)
+	}
+	if err := req.Verify(ts); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+}
+
+func TestSendTxRequest_Verify(t *testing.T) {
+	ts := testSignedTxStruct()
+	req, _ := NewSendTxRequest(ts)
+
+	//数值相同、格式不同的金额视为一致
+	req.Tx.Amount = "0.012340"
+	if err := req.Verify(ts); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	req.Tx.Nonce = 2
+	if err := req.Verify(ts); !errors.Is(err, ErrSendPayloadMismatch) {
+		t.Fatalf("expected ErrSendPayloadMismatch, got %v", err)
+	}
+}
+
+func TestDecodeSendTxRequest_Invalid(t *testing.T) {
+	cases := []string{
+		`{"tx":{"hash":"h","amount":"abc","fee":0.1}}`,
+		`{"tx":{"hash":"h","amount":1,"fee":0.1,"extra":1}}`,
+		`{"tx":`,
+	}
+	for _, c := range cases {
+		if _, err := DecodeSendTxRequest(c); !errors.Is(err, ErrInvalidSendPayload) {
+			t.Errorf("%s: expected ErrInvalidSendPayload, got %v", c, err)
+		}
+	}
+
+	if _, err := NewSendTxRequest(&TxStruct{Hash: "h"}); !errors.Is(err, ErrInvalidSendPayload) {
+		t.Errorf("expected ErrInvalidSendPayload, got %v", err)
+	}
+}
@@ -28,21 +28,54 @@ func SignTransaction(msgStr string, prikey []byte) ([]byte, error) {
 	return signature, nil
 }
 
-func VerifyAndCombineTransaction(emptyTrans, signature string, pubkey []byte) (string, bool) {
+var (
+	ErrInvalidTxStruct       = errors.New("invalid transaction struct")
+	ErrTxHashMismatch        = errors.New("transaction hash mismatch")
+	ErrInvalidPublicKey      = errors.New("invalid public key")
+	ErrInvalidSignature      = errors.New("invalid signature")
+	ErrSignatureVerifyFailed = errors.New("signature verify failed")
+	ErrSenderMismatch        = errors.New("public key does not match sender address")
+)
+
+//VerifyAndCombineTransaction 校验交易hash、签名及发送地址，通过后合并签名到交易单
+func VerifyAndCombineTransaction(emptyTrans, signature string, pubkey []byte, from string) (string, error) {
 	ts, err := NewTxStructFromJSON(emptyTrans)
 	if err != nil {
-		return "", false
+		return "", fmt.Errorf("%w: %v", ErrInvalidTxStruct, err)
 	}
 
-	sig, err := hex.DecodeString(signature)
+	if ts.Amount == nil || ts.Fee == nil {
+		return "", fmt.Errorf("%w: amount or fee is empty", ErrInvalidTxStruct)
+	}
+
+	//重新计算交易hash及待签名消息
+	hash, message := ts.GetHashAndMessage()
+	if ts.Hash != hex.EncodeToString(hash) {
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrTxHashMismatch, hex.EncodeToString(hash), ts.Hash)
+	}
+
+	pubkey, err = NormalizePublicKey(pubkey)
 	if err != nil {
-		return "", false
+		return "", fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != 64 {
+		return "", ErrInvalidSignature
 	}
 	sig = serilizeS( sig )
 
-	if len(pubkey) != 32 {
-		//公钥hash处理
-		pubkey = owcrypt.PointDecompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+	if owcrypt.Verify(pubkey[1:], nil, message, sig, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		return "", ErrSignatureVerifyFailed
+	}
+
+	//公钥对应的地址必须是发送地址
+	address, err := GetAddressByPublicKey(pubkey)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	if address != from {
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrSenderMismatch, from, address)
 	}
 
 	sigPub := SignaturePubkey{
@@ -53,5 +86,5 @@ func VerifyAndCombineTransaction(emptyTrans, signature string, pubkey []byte) (s
 
 	ts.Sig = hex.EncodeToString(derSig) + "@" + hex.EncodeToString(pubkey)
 
-	return ts.ToJSONString(), true
-}
\ No newline at end of file
+	return ts.ToJSONString(), nil
+}
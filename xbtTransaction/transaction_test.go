@@ -2,11 +2,13 @@ package xbtTransaction
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/shopspring/decimal"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -30,19 +32,12 @@ func Test_Transaction(t *testing.T)  {
 		return
 	}
 
-	signedTrans, _ := VerifyAndCombineTransaction(emptyTrans, hex.EncodeToString(signature), pubkey)
+	from, _ := GetAddressByPublicKey(pubkey)
+	signedTrans, _ := VerifyAndCombineTransaction(emptyTrans, hex.EncodeToString(signature), pubkey, from)
 
 	ts, err := NewTxStructFromJSON(signedTrans)
 
-	tx := `{"tx":{`
-	tx = tx + "\"hash\":\"" + ts.Hash + "\","
-	tx = tx + "\"to\":\"" + ts.To + "\","
-	tx = tx + "\"amount\":" + ts.Amount.String() + `,`
-	tx = tx + "\"fee\":" + ts.Fee.String() + `,`
-	tx = tx + "\"nonce\":" + strconv.FormatUint(ts.Nonce, 10) + `,`
-	tx = tx + "\"time\":" + strconv.FormatUint(ts.Time, 10) + `,`
-	tx = tx + "\"sig\":\"" + ts.Sig + "\""
-	tx = tx + `}}`
+	tx, _ := EncodeSendTxRequest(ts)
 
 	fmt.Println("curl -H 'Content-Type: application/json' -d'", tx, "' https://api.xbt.wang/transaction/emit")
 }
@@ -109,15 +104,7 @@ func Test_json(t *testing.T)  {
 	ts.Hash = hex.EncodeToString(message)
 	ts.Sig = result
 
-	tx := `{"tx":{`
-	tx = tx + "\"hash\":\"" + ts.Hash + "\","
-	tx = tx + "\"to\":\"" + ts.To + "\","
-	tx = tx + "\"amount\":" + ts.Amount.String() + `,`
-	tx = tx + "\"fee\":" + ts.Fee.String() + `,`
-	tx = tx + "\"nonce\":" + strconv.FormatUint(ts.Nonce, 10) + `,`
-	tx = tx + "\"time\":" + strconv.FormatUint(ts.Time, 10) + `,`
-	tx = tx + "\"sig\":\"" + ts.Sig + "\""
-	tx = tx + `}}`
+	tx, _ := EncodeSendTxRequest(&ts)
 
 	fmt.Println("curl -H 'Content-Type: application/json' -d'", tx, "' http://127.0.0.1:3000/transaction/emit")
 }
@@ -128,4 +115,61 @@ func Test_Random(t *testing.T){
 		nonce = uint64( rand.Int63n(2147483647) )
 		fmt.Println( nonce )
 	}
-}
\ No newline at end of file
+}
+
+func Test_VerifyAndCombineTransaction(t *testing.T) {
+	prikey, _ := hex.DecodeString("4f3edf983ac636a65a842ce7c78d9aa706d3b113bce9c46f30d7d21715b23b1d")
+	pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	from, _ := GetAddressByPublicKey(pubkey)
+
+	to := "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B"
+	amount, _ := decimal.NewFromString("0.01234")
+	fee, _ := decimal.NewFromString("0.1")
+
+	txStruct, message, err := GetTxStruct(to, &amount, &fee)
+	if err != nil {
+		t.Fatalf("GetTxStruct failed: %v", err)
+	}
+	emptyTrans := txStruct.ToJSONString()
+
+	signature, err := SignTransaction(hex.EncodeToString(message), prikey)
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	sigHex := hex.EncodeToString(signature)
+
+	signedTrans, err := VerifyAndCombineTransaction(emptyTrans, sigHex, pubkey, from)
+	if err != nil {
+		t.Fatalf("VerifyAndCombineTransaction failed: %v", err)
+	}
+	ts, _ := NewTxStructFromJSON(signedTrans)
+	if !strings.HasSuffix(ts.Sig, "@04"+hex.EncodeToString(pubkey)) {
+		t.Errorf("unexpected sig: %s", ts.Sig)
+	}
+
+	//发送地址不匹配
+	_, err = VerifyAndCombineTransaction(emptyTrans, sigHex, pubkey, to)
+	if !errors.Is(err, ErrSenderMismatch) {
+		t.Errorf("expected ErrSenderMismatch, got %v", err)
+	}
+
+	//交易内容被篡改
+	tampered := txStruct
+	tampered.Nonce = tampered.Nonce + 1
+	_, err = VerifyAndCombineTransaction(tampered.ToJSONString(), sigHex, pubkey, from)
+	if !errors.Is(err, ErrTxHashMismatch) {
+		t.Errorf("expected ErrTxHashMismatch, got %v", err)
+	}
+
+	//签名错误
+	badSig := []byte(sigHex)
+	if badSig[10] == '0' {
+		badSig[10] = '1'
+	} else {
+		badSig[10] = '0'
+	}
+	_, err = VerifyAndCombineTransaction(emptyTrans, string(badSig), pubkey, from)
+	if !errors.Is(err, ErrSignatureVerifyFailed) {
+		t.Errorf("expected ErrSignatureVerifyFailed, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package xbtTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidTxParams = errors.New("invalid transaction params")
+
+//Clock 交易时间来源，返回毫秒时间戳
+type Clock func() uint64
+
+//NonceSource nonce来源
+type NonceSource func() uint64
+
+//SystemClock 系统时间
+func SystemClock() uint64 {
+	return uint64(time.Now().UnixNano() / 1e6)
+}
+
+//FixedClock 固定的交易时间，用于测试及重建交易
+func FixedClock(ms uint64) Clock {
+	return func() uint64 { return ms }
+}
+
+//FixedNonce 固定的nonce，用于测试及重建交易
+func FixedNonce(nonce uint64) NonceSource {
+	return func() uint64 { return nonce }
+}
+
+//TxBuilder 交易构建器，时间及nonce来源可替换，相同输入得到相同的交易
+type TxBuilder struct {
+	Clock Clock
+	Nonce NonceSource
+}
+
+//BuiltTx 构建的交易及签名相关数据
+type BuiltTx struct {
+	Tx          TxStruct
+	Preimage    string //交易原文：["to",amount,fee,nonce,time]
+	Hash        []byte //交易hash = sha3(原文)
+	MessageHash []byte //待签名消息 = sha3(hex(交易hash))
+}
+
+//NewTxBuilder 使用系统时间及随机nonce的构建器
+func NewTxBuilder() *TxBuilder {
+	return &TxBuilder{Clock: SystemClock, Nonce: RandomNonce}
+}
+
+//Build 使用构建器的nonce来源生成交易
+func (b *TxBuilder) Build(to string, amount, fee *decimal.Decimal) (*BuiltTx, error) {
+	nonce := b.Nonce
+	if nonce == nil {
+		nonce = RandomNonce
+	}
+	return b.BuildWithNonce(to, amount, fee, nonce())
+}
+
+//BuildWithNonce 使用指定的nonce生成交易
+func (b *TxBuilder) BuildWithNonce(to string, amount, fee *decimal.Decimal, nonce uint64) (*BuiltTx, error) {
+	if len(to) == 0 || amount == nil || fee == nil {
+		return nil, ErrInvalidTxParams
+	}
+
+	clock := b.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return Rebuild(TxStruct{
+		To:     to,
+		Amount: amount,
+		Fee:    fee,
+		Nonce:  nonce,
+		Time:   clock(),
+	}), nil
+}
+
+//Rebuild 根据已有交易的字段重新计算原文、hash及待签名消息，忽略原有的hash及签名
+func Rebuild(ts TxStruct) *BuiltTx {
+	hash, messageHash := ts.GetHashAndMessage()
+
+	ts.Hash = hex.EncodeToString(hash)
+	ts.Sig = ""
+
+	return &BuiltTx{
+		Tx:          ts,
+		Preimage:    ts.GetPreimage(),
+		Hash:        hash,
+		MessageHash: messageHash,
+	}
+}
@@ -0,0 +1,85 @@
+package xbtTransaction
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+//txBuilderVectors 已知答案向量，hash与python hashlib.sha3_256计算结果一致
+var txBuilderVectors = []struct {
+	to          string
+	amount      string
+	fee         string
+	nonce       uint64
+	time        uint64
+	preimage    string
+	hash        string
+	messageHash string
+}{
+	{
+		to: "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B", amount: "0.01234", fee: "0.1", nonce: 1, time: 1600000000000,
+		preimage:    `["xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B",0.01234,0.1,1,1600000000000]`,
+		hash:        "67ce776f3a8e10bd99c9f4db845c3bcb6e00146c5de06b50b2fb0c0a96a0dbe0",
+		messageHash: "b42b9539428c7782e259153b2e9a00eef19d3ce245dd22c2bb8359f6cfff50f0",
+	},
+	{
+		to: "xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B", amount: "100", fee: "0.002", nonce: 2147483646, time: 1600000000123,
+		preimage:    `["xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B",100,0.002,2147483646,1600000000123]`,
+		hash:        "6a366475942af773344065e18f11aa96242dbb1dce0718c9de25710e09b36226",
+		messageHash: "2a95b0cdf0fbe1dcf62077e35e6c84540e6a161a1d16cc7a7951bd7bdfcb7e9d",
+	},
+	{
+		//金额末尾的0不进入原文
+		to: "xB4d5A6eA3C4e3F1b0C0a2E7f8a9b1C2d3E4F5a6B7", amount: "1.50", fee: "0", nonce: 42, time: 1,
+		preimage:    `["xB4d5A6eA3C4e3F1b0C0a2E7f8a9b1C2d3E4F5a6B7",1.5,0,42,1]`,
+		hash:        "04c24fd41d8375a5231e2f2e2ebd6f4f239538b8cc88d3ac16fec63189b175a7",
+		messageHash: "147be953b23940bd03d9484b3df2e34c75431606cd3b8185afcea65f226490a5",
+	},
+}
+
+func TestTxBuilder_KnownAnswers(t *testing.T) {
+	for i, v := range txBuilderVectors {
+		amount, _ := decimal.NewFromString(v.amount)
+		fee, _ := decimal.NewFromString(v.fee)
+
+		b := &TxBuilder{Clock: FixedClock(v.time), Nonce: FixedNonce(v.nonce)}
+		built, err := b.Build(v.to, &amount, &fee)
+		if err != nil {
+			t.Fatalf("vector %d: Build failed: %v", i, err)
+		}
+
+		if built.Preimage != v.preimage {
+			t.Errorf("vector %d: preimage = %s, want %s", i, built.Preimage, v.preimage)
+		}
+		if hex.EncodeToString(built.Hash) != v.hash {
+			t.Errorf("vector %d: hash = %x, want %s", i, built.Hash, v.hash)
+		}
+		if hex.EncodeToString(built.MessageHash) != v.messageHash {
+			t.Errorf("vector %d: message hash = %x, want %s", i, built.MessageHash, v.messageHash)
+		}
+		if built.Tx.Hash != v.hash || built.Tx.Nonce != v.nonce || built.Tx.Time != v.time {
+			t.Errorf("vector %d: unexpected tx struct: %s", i, built.Tx.ToJSONString())
+		}
+
+		//从json重建得到相同结果
+		ts, _ := NewTxStructFromJSON(built.Tx.ToJSONString())
+		rebuilt := Rebuild(*ts)
+		if rebuilt.Preimage != built.Preimage || rebuilt.Tx.Hash != built.Tx.Hash {
+			t.Errorf("vector %d: rebuild mismatch: %s", i, rebuilt.Preimage)
+		}
+	}
+}
+
+func TestTxBuilder_InvalidParams(t *testing.T) {
+	amount := decimal.New(1, 0)
+	b := NewTxBuilder()
+
+	if _, err := b.Build("", &amount, &amount); err != ErrInvalidTxParams {
+		t.Errorf("empty to: expected ErrInvalidTxParams, got %v", err)
+	}
+	if _, err := b.Build("xB52c55E62d708CdE25Cec9B576F5bFDEcFB5C328B", nil, &amount); err != ErrInvalidTxParams {
+		t.Errorf("nil amount: expected ErrInvalidTxParams, got %v", err)
+	}
+}
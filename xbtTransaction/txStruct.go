@@ -1,11 +1,12 @@
 package xbtTransaction
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/shopspring/decimal"
-	"math/rand"
+	"math/big"
 	"strconv"
 	"time"
 )
@@ -20,18 +21,33 @@ type TxStruct struct {
 	Sig string `json:"sig"`
 }
 
+//GetTxStruct 使用随机nonce生成交易，只适用于链上允许非递增nonce的情况
 func GetTxStruct(to string, amount, fee *decimal.Decimal) (TxStruct, []byte, error){
-	nonce := uint64( rand.Int63n(2147483647) )
-	txTime := uint64( time.Now().UnixNano() / 1e6 )
-
-	ts := TxStruct{
-		To     :    to,
-		Amount :     amount,
-		Nonce  :     nonce,
-		Fee    :     fee,
-		Time   :     txTime,
+	return GetTxStructWithNonce(to, amount, fee, RandomNonce())
+}
+
+//RandomNonce 随机nonce，范围[1, 2147483647)
+func RandomNonce() uint64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(2147483646))
+	if err != nil {
+		//系统随机源不可用时退回时间戳
+		return uint64(time.Now().UnixNano()%2147483646) + 1
 	}
+	return n.Uint64() + 1
+}
 
+//GetTxStructWithNonce 使用指定的nonce及系统时间生成交易
+func GetTxStructWithNonce(to string, amount, fee *decimal.Decimal, nonce uint64) (TxStruct, []byte, error){
+	built, err := NewTxBuilder().BuildWithNonce(to, amount, fee, nonce)
+	if err != nil {
+		return TxStruct{}, nil, err
+	}
+
+	return built.Tx, built.MessageHash, nil
+}
+
+//GetPreimage 交易签名原文，格式：["to",amount,fee,nonce,time]
+func (ts TxStruct) GetPreimage() string {
 	txString := `[`
 	txString = txString + "\"" + ts.To + "\","
 	txString = txString + ts.Amount.String() + `,`
@@ -39,16 +55,17 @@ func GetTxStruct(to string, amount, fee *decimal.Decimal) (TxStruct, []byte, err
 	txString = txString + strconv.FormatUint(ts.Nonce, 10) + `,`
 	txString = txString + strconv.FormatUint(ts.Time, 10)
 	txString = txString + `]`
+	return txString
+}
 
-	txStringBytes := []byte( txString )
-	message := owcrypt.Hash(txStringBytes, 0, owcrypt.HASH_ALG_SHA3_256)
+//GetHashAndMessage 交易hash = sha3(原文)，待签名消息 = sha3(hex(交易hash))
+func (ts TxStruct) GetHashAndMessage() ([]byte, []byte) {
+	message := owcrypt.Hash([]byte(ts.GetPreimage()), 0, owcrypt.HASH_ALG_SHA3_256)
 
 	messageBytes := []byte( hex.EncodeToString(message) )
 	messageHash := owcrypt.Hash(messageBytes, 0, owcrypt.HASH_ALG_SHA3_256)
 
-	ts.Hash = hex.EncodeToString(message)
-
-	return ts, messageHash, nil
+	return message, messageHash
 }
 
 func (tx TxStruct) ToJSONString() string {